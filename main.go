@@ -13,91 +13,389 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/prometheus/common/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"k8s.io/client-go/kubernetes"
 
+	"github.com/nabokihms/events_exporter/pkg/config"
+	"github.com/nabokihms/events_exporter/pkg/correlation"
+	"github.com/nabokihms/events_exporter/pkg/eventmetric"
 	"github.com/nabokihms/events_exporter/pkg/kube"
+	"github.com/nabokihms/events_exporter/pkg/logging"
+	"github.com/nabokihms/events_exporter/pkg/notifier"
+	"github.com/nabokihms/events_exporter/pkg/remotewrite"
 	"github.com/nabokihms/events_exporter/pkg/server"
+	"github.com/nabokihms/events_exporter/pkg/sink"
+	"github.com/nabokihms/events_exporter/pkg/snapshot"
 	"github.com/nabokihms/events_exporter/pkg/vault"
 )
 
 func main() {
 	var (
+		configFile         = ""
 		exporterAddress    = ":9000"
+		webConfigFile      = ""
 		logLevel           = "info"
+		logVerbosity       = 0
+		logFormat          = logging.FormatJSON
 		kubeconfig         = ""
 		fieldSelector      = ""
 		omitEventsMessages = false
+		watchOnly          = false
+		eventsCounter      = false
+		eventsTimestamps   = false
+		explicitTimestamps = false
 		eventsTTL          = time.Hour
+		runtimeMetrics     = false
+		metricsPath        = "/metrics"
+		internalPath       = "/internal/metrics"
+		snapshotFile       = ""
+		snapshotConfigMap  = ""
+		snapshotInterval   = time.Minute
+		storageType        = "memory"
+		storagePath        = "events_exporter.db"
+		cleanupInterval    = time.Second
+		seriesLimit        = 0
+		rulePacks          = ""
+		eventMetrics       = false
 	)
 
+	flag.StringVar(&configFile, "config.file", configFile, "Path to the configuration file (optional)")
 	flag.StringVar(&exporterAddress, "server.exporter-address", exporterAddress, "Address to export prometheus metrics")
-	flag.StringVar(&logLevel, "server.log-level", logLevel, "Log level (logs all incoming events if debug)")
+	flag.StringVar(&webConfigFile, "web.config.file", webConfigFile, "Path to the web config file to enable TLS or authentication (optional)")
+	flag.StringVar(&metricsPath, "server.metrics-path", metricsPath, "Path to expose events metrics")
+	flag.StringVar(&internalPath, "server.internal-metrics-path", internalPath, "Path to expose metrics of the exporter itself (exposed with events metrics if empty)")
+	flag.BoolVar(&runtimeMetrics, "server.runtime-metrics", runtimeMetrics, "Expose Go runtime and process metrics of the exporter")
+	flag.StringVar(&logLevel, "server.log-level", logLevel, "Log level: debug, info, warn or error (logs all incoming events if debug)")
+	flag.IntVar(&logVerbosity, "server.log-verbosity", logVerbosity, "Max verbosity of debug logs, debug logs have verbosity 1 and Kubernetes clients log up to 10")
+	flag.StringVar(&logFormat, "server.log-format", logFormat, "Log format: json or text")
 	flag.StringVar(&kubeconfig, "kube.config", kubeconfig, "Path to kubeconfig (optional)")
 	flag.StringVar(&fieldSelector, "kube.field-selector", fieldSelector, "Events filter as for kubectl")
 	flag.BoolVar(&omitEventsMessages, "kube.omit-events-messages", omitEventsMessages, "Do not expose message field from events (it reduces cardinality)")
+	flag.BoolVar(&watchOnly, "kube.watch-only", watchOnly, "Stream events without keeping them in the informer cache (reduces memory usage)")
+	flag.BoolVar(&eventsCounter, "kube.events-counter", eventsCounter, "Also expose events count as the kube_event_count_total counter with exemplars linking to events")
+	flag.BoolVar(&eventsTimestamps, "kube.events-timestamps", eventsTimestamps, "Expose first and last timestamps of events as separate gauges")
+	flag.BoolVar(&explicitTimestamps, "kube.explicit-timestamps", explicitTimestamps, "Expose events with their last timestamp instead of the scrape time")
 	flag.DurationVar(&eventsTTL, "kube.events-ttl", eventsTTL, "For how long to keep stale events")
+	flag.BoolVar(&eventMetrics, "kube.event-metrics", eventMetrics, "Expose metrics declared by EventMetric and ClusterEventMetric custom resources")
+	flag.StringVar(&rulePacks, "kube.rule-packs", rulePacks, "Comma-separated built-in rule packs of derived workload health metrics: "+strings.Join(kube.RulePackNames(), ", "))
+
+	flag.StringVar(&storageType, "vault.storage", storageType, "Where to keep events metrics: memory or bolt (on-disk database)")
+	flag.StringVar(&storagePath, "vault.storage-path", storagePath, "Path to the database file for the bolt storage")
+	flag.DurationVar(&cleanupInterval, "vault.cleanup-interval", cleanupInterval, "How often to remove expired events metrics")
+	flag.IntVar(&seriesLimit, "vault.series-limit", seriesLimit, "Max number of series of every events metric, new series over the limit are dropped (0 means no limit)")
+	flag.StringVar(&snapshotFile, "vault.snapshot-file", snapshotFile, "Path to the file to persist the vault state across restarts (optional)")
+	flag.StringVar(&snapshotConfigMap, "vault.snapshot-configmap", snapshotConfigMap, "Namespace/name of the ConfigMap to persist the vault state across restarts (optional)")
+	flag.DurationVar(&snapshotInterval, "vault.snapshot-interval", snapshotInterval, "How often to save the vault state")
 
 	flag.Parse()
 
-	if err := log.Base().SetFormat("logger:stdout?json=true"); err != nil {
-		log.Fatalf("error formating logger: %v", err)
+	if err := logging.Setup(logging.Config{Level: logLevel, Verbosity: logVerbosity, Format: logFormat}); err != nil {
+		fmt.Fprintf(os.Stderr, "logger: %v\n", err)
+		os.Exit(1)
+	}
+	logger := logging.Logger("")
+
+	if cleanupInterval <= 0 {
+		fatal(fmt.Errorf("got %v", cleanupInterval), "cleanup interval must be positive")
 	}
 
-	if err := log.Base().SetLevel(logLevel); err != nil {
-		log.Fatalf("set log level: %v", err)
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		fatal(err, "configuration")
 	}
 
 	errorCh := make(chan error)
 	stopCh := make(chan struct{})
 
-	metricsVault := vault.NewVault()
-	err := metricsVault.RegisterMappings([]vault.Mapping{kube.EventMapping(eventsTTL)})
+	// backgroundTasks are waited for on exit, e.g., to push the last metrics snapshot.
+	var backgroundTasks sync.WaitGroup
+
+	messageParsers, err := kube.NewMessageParsers(cfg.MessageParsers)
 	if err != nil {
-		log.Fatalf("mappings registration: %v", err)
+		fatal(err, "message parsers")
+	}
+	converter := &kube.EventConverter{
+		OmitMessages:   omitEventsMessages,
+		Parsers:        messageParsers,
+		RelabelConfigs: cfg.RelabelConfigs,
 	}
 
-	informer, err := kube.NewEventsInformer(kubeconfig, fieldSelector, kube.EventCallback(metricsVault, omitEventsMessages))
+	eventMapping := kube.EventMapping(eventsTTL)
+	if eventsTimestamps {
+		eventMapping = kube.WithEventTimestamps(eventMapping)
+	}
+
+	mappings := []vault.Mapping{eventMapping}
+	if eventsCounter {
+		mappings = append(mappings, kube.EventCountMapping(eventsTTL))
+	}
+
+	for i := range mappings {
+		mappings[i].LabelNames = converter.LabelNames()
+		mappings[i].ExplicitTimestamps = explicitTimestamps
+		mappings[i].TTLRules = cfg.TTLRules
+		mappings[i].SeriesLimit = seriesLimit
+	}
+
+	// Values captured from messages have their own labels, so TTL rules of events do not apply to them.
+	valueMappings := messageParsers.Mappings(eventsTTL)
+	for i := range valueMappings {
+		valueMappings[i].ExplicitTimestamps = explicitTimestamps
+		valueMappings[i].SeriesLimit = seriesLimit
+	}
+
+	var (
+		metricsVault *vault.MetricsVault
+		boltDB       *vault.BoltDB
+	)
+	switch storageType {
+	case "memory":
+		metricsVault = vault.NewVault()
+	case "bolt":
+		boltDB, err = vault.OpenBoltDB(storagePath)
+		if err != nil {
+			fatal(err, "vault storage")
+		}
+		metricsVault = vault.NewVaultWithStorage(boltDB.StorageFactory)
+	default:
+		fatal(fmt.Errorf("unknown type %q", storageType), "vault storage")
+	}
+
+	err = metricsVault.RegisterMappings(append(mappings, valueMappings...))
 	if err != nil {
-		log.Fatalf("kubernetes informer: %v", err)
+		fatal(err, "mappings registration")
+	}
+
+	snapshotStore, err := newSnapshotStore(snapshotFile, snapshotConfigMap, kubeconfig)
+	if err != nil {
+		fatal(err, "vault snapshot")
+	}
+	if snapshotStore != nil {
+		snapshotter := snapshot.NewSnapshotter(metricsVault, snapshotStore, snapshotInterval)
+		// The state is restored before the first sync, so relisted events continue from the saved state.
+		if err := snapshotter.Restore(context.Background()); err != nil {
+			logger.Error(err, "vault snapshot")
+		}
+
+		backgroundTasks.Add(1)
+		go func() {
+			defer backgroundTasks.Done()
+			snapshotter.Run(stopCh)
+		}()
+	}
+
+	eventsStream := server.NewEventsStream()
+	metricsVault.AddStoreListener(eventsStream)
+
+	// internalRegistry keeps metrics of the exporter itself apart from events metrics.
+	internalRegistry := prometheus.NewRegistry()
+	if runtimeMetrics {
+		internalRegistry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+	remotewrite.MustRegisterMetrics(internalRegistry)
+	sink.MustRegisterMetrics(internalRegistry)
+	notifier.MustRegisterMetrics(internalRegistry)
+	server.MustRegisterMetrics(internalRegistry)
+	vault.MustRegisterMetrics(internalRegistry)
+	kube.MustRegisterMetrics(internalRegistry)
+
+	sinks, err := sink.NewDispatcher(cfg.Sinks, eventsTTL)
+	if err != nil {
+		fatal(err, "sinks")
+	}
+
+	backgroundTasks.Add(1)
+	go func() {
+		defer backgroundTasks.Done()
+		sinks.Run(stopCh)
+	}()
+
+	eventsNotifier, err := notifier.New(cfg.Notifier, eventsTTL)
+	if err != nil {
+		fatal(err, "notifier")
+	}
+
+	backgroundTasks.Add(1)
+	go func() {
+		defer backgroundTasks.Done()
+		eventsNotifier.Run(stopCh)
+	}()
+
+	listeners := []kube.EventListener{sinks.Handle, eventsNotifier.Handle}
+
+	// derivedRegistry keeps metrics derived from events that are not stored in the vault.
+	derivedRegistry := prometheus.NewRegistry()
+	eventsGatherer := prometheus.Gatherers{metricsVault, derivedRegistry}
+
+	if cfg.Correlation.Enabled() {
+		correlator := correlation.NewCorrelator(cfg.Correlation)
+		listeners = append(listeners, correlator.Handle)
+		derivedRegistry.MustRegister(correlator)
+	}
+
+	if rulePacks != "" {
+		derivedMetrics, err := kube.NewDerivedMetrics(strings.Split(rulePacks, ","), eventsTTL)
+		if err != nil {
+			fatal(err, "rule packs")
+		}
+		listeners = append(listeners, derivedMetrics.Handle)
+		derivedRegistry.MustRegister(derivedMetrics)
+	}
+
+	var eventMetricsController *eventmetric.Controller
+	if eventMetrics {
+		dynamicClient, err := kube.NewDynamicClient(kubeconfig)
+		if err != nil {
+			fatal(err, "event metrics client")
+		}
+		// Declared metrics have labels of events chosen by their resources, so TTL rules do not apply to them.
+		template := vault.Mapping{TTL: eventsTTL, SeriesLimit: seriesLimit, ExplicitTimestamps: explicitTimestamps}
+		eventMetricsController = eventmetric.NewController(dynamicClient, metricsVault, converter, template)
+		listeners = append(listeners, eventMetricsController.Handle)
+	}
+
+	callback := kube.EventCallback(metricsVault, mappings, converter, listeners...)
+
+	var informer kube.EventsSource
+	if watchOnly {
+		informer, err = kube.NewEventsWatcher(kubeconfig, fieldSelector, callback)
+	} else {
+		informer, err = kube.NewEventsInformer(kubeconfig, fieldSelector, callback)
+	}
+	if err != nil {
+		fatal(err, "kubernetes informer")
 	}
 
 	// TODO(nabokihms): Ensure that after starting informer we clear all stale events before starting web server
 	go func() {
+		// Declared metrics are registered before events are listed.
+		if eventMetricsController != nil {
+			eventMetricsController.Run(stopCh, errorCh)
+		}
 		informer.Run(stopCh, errorCh)
 		metricsVault.RemoveStaleMetrics()
 	}()
 
-	metricsServer := server.NewMetricsServer()
+	for _, remoteWriteConfig := range cfg.RemoteWrite {
+		// Remote storages get the same metrics as scrapes of the metrics endpoint.
+		writer, err := remotewrite.NewWriter(remoteWriteConfig, eventsGatherer)
+		if err != nil {
+			fatal(err, "remote write")
+		}
+
+		backgroundTasks.Add(1)
+		go func() {
+			defer backgroundTasks.Done()
+			writer.Run(stopCh)
+		}()
+	}
+
+	webConfig, err := server.LoadWebConfig(webConfigFile)
+	if err != nil {
+		fatal(err, "web configuration")
+	}
+
+	var authClient kubernetes.Interface
+	if webConfig.KubernetesAuth != nil {
+		authClient, err = kube.NewClient(kubeconfig)
+		if err != nil {
+			fatal(err, "kubernetes auth client")
+		}
+	}
+
+	// Events series are huge in numbers, so they are served apart from internals to be scraped differently.
+	endpoints := []server.Endpoint{{Path: metricsPath, Gatherer: eventsGatherer, Registerer: internalRegistry}}
+	if internalPath == "" || internalPath == metricsPath {
+		endpoints[0].Gatherer = prometheus.Gatherers{eventsGatherer, internalRegistry}
+	} else {
+		endpoints = append(endpoints, server.Endpoint{Path: internalPath, Gatherer: internalRegistry, Registerer: internalRegistry})
+	}
+
+	metricsServer, err := server.NewMetricsServer(endpoints, webConfig, authClient)
+	if err != nil {
+		fatal(err, "metrics server")
+	}
+	metricsServer.Handle("/api/v1/events", server.NewEventsAPI(metricsVault))
+	metricsServer.Handle("/api/v1/status", server.NewStatusAPI(informer, metricsVault))
+	metricsServer.Handle("/api/v1/events/stream", eventsStream)
+	metricsServer.RegisterOnShutdown(eventsStream.Close)
 	go metricsServer.Start(exporterAddress, errorCh)
 
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
 	// TODO (nabokihms): check that every concurrent task stops correctly
-	tick := time.NewTicker(time.Second)
+	tick := time.NewTicker(cleanupInterval)
+
+	// shutdown stops all tasks and waits for background ones, so the last snapshot and queued entries are flushed
+	// on errors too.
+	shutdown := func(code int) {
+		close(stopCh)
+		metricsServer.Close()
+		tick.Stop()
+		backgroundTasks.Wait()
+		if boltDB != nil {
+			if err := boltDB.Close(); err != nil {
+				logger.Error(err, "close vault storage")
+			}
+		}
+		os.Exit(code)
+	}
+
 	for {
 		select {
 		case <-tick.C:
 			// TODO(nabokihms): think about setting tombstones instead of deleting
 			metricsVault.RemoveStaleMetrics()
 		case s := <-signalChan:
-			log.Warnf("signal received: %v, exiting...", s)
-			close(stopCh)
-			metricsServer.Close()
-			tick.Stop()
-			os.Exit(0)
+			logger.Info("signal received, exiting", "signal", s.String())
+			shutdown(0)
 		case e := <-errorCh:
-			log.Errorf("error received: %v", e)
-			close(stopCh)
-			metricsServer.Close()
-			tick.Stop()
-			os.Exit(1)
+			logger.Error(e, "error received, exiting")
+			shutdown(1)
+		}
+	}
+}
+
+// newSnapshotStore returns the store for vault snapshots, or nil if persistence is disabled.
+func newSnapshotStore(file, configMap, kubeconfig string) (snapshot.Store, error) {
+	switch {
+	case file != "" && configMap != "":
+		return nil, errors.New("snapshot file and ConfigMap cannot be used together")
+	case file != "":
+		return snapshot.NewFileStore(file), nil
+	case configMap != "":
+		namespace, name, ok := strings.Cut(configMap, "/")
+		if !ok || namespace == "" || name == "" {
+			return nil, fmt.Errorf("ConfigMap %q must be in the namespace/name format", configMap)
+		}
+		client, err := kube.NewClient(kubeconfig)
+		if err != nil {
+			return nil, err
 		}
+		return snapshot.NewConfigMapStore(client, namespace, name), nil
+	default:
+		return nil, nil
 	}
 }
+
+// fatal logs the error and exits.
+func fatal(err error, msg string) {
+	logging.Logger("").WithCallDepth(1).Error(err, msg)
+	os.Exit(1)
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/nabokihms/events_exporter/pkg/correlation"
+	"github.com/nabokihms/events_exporter/pkg/kube"
+	"github.com/nabokihms/events_exporter/pkg/notifier"
+	"github.com/nabokihms/events_exporter/pkg/relabel"
+	"github.com/nabokihms/events_exporter/pkg/remotewrite"
+	"github.com/nabokihms/events_exporter/pkg/sink"
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+// Config is the content of the exporter configuration file. Everything that does not fit into flags lives here.
+type Config struct {
+	RemoteWrite []remotewrite.Config `yaml:"remote_write,omitempty"`
+	Sinks       []sink.Config        `yaml:"sinks,omitempty"`
+	Notifier    notifier.Config      `yaml:"notifier,omitempty"`
+	// TTLRules override --kube.events-ttl for events with matching labels.
+	TTLRules []vault.TTLRule `yaml:"ttl_rules,omitempty"`
+	// RelabelConfigs rewrite labels of event samples before they are stored.
+	RelabelConfigs []*relabel.Config  `yaml:"relabel_configs,omitempty"`
+	Correlation    correlation.Config `yaml:"correlation,omitempty"`
+	// MessageParsers extract labels and values from messages of events.
+	MessageParsers []kube.MessageParserConfig `yaml:"message_parsers,omitempty"`
+}
+
+// Load reads the configuration file. Empty path means the default configuration.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package correlation
+
+import (
+	"fmt"
+	"time"
+)
+
+// Config is the correlation section of the configuration file. Nil sections are disabled.
+type Config struct {
+	Burst    *BurstConfig    `yaml:"burst,omitempty"`
+	Flapping *FlappingConfig `yaml:"flapping,omitempty"`
+}
+
+// BurstConfig detects objects emitting many events with the same reason in a short time.
+type BurstConfig struct {
+	Window time.Duration `yaml:"window,omitempty"`
+	// Threshold is the min number of events in the window to expose the burst.
+	Threshold int `yaml:"threshold,omitempty"`
+	// Types of counted events, Warning by default.
+	Types []string `yaml:"types,omitempty"`
+}
+
+// FlappingConfig detects objects alternating between event reasons, e.g., Started and BackOff.
+type FlappingConfig struct {
+	Window time.Duration `yaml:"window,omitempty"`
+	// Threshold is the min number of reason changes in the window to expose the flapping score.
+	Threshold int `yaml:"threshold,omitempty"`
+	// Reasons of counted events, all reasons by default.
+	Reasons []string `yaml:"reasons,omitempty"`
+}
+
+// DefaultBurstConfig is applied to the burst section before unmarshalling.
+var DefaultBurstConfig = BurstConfig{
+	Window:    10 * time.Minute,
+	Threshold: 5,
+	Types:     []string{"Warning"},
+}
+
+// DefaultFlappingConfig is applied to the flapping section before unmarshalling.
+var DefaultFlappingConfig = FlappingConfig{
+	Window:    30 * time.Minute,
+	Threshold: 4,
+}
+
+// UnmarshalYAML sets defaults for omitted fields.
+func (c *BurstConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultBurstConfig
+	type plain BurstConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Window <= 0 || c.Threshold <= 0 {
+		return fmt.Errorf("burst window and threshold must be positive")
+	}
+	return nil
+}
+
+// UnmarshalYAML sets defaults for omitted fields.
+func (c *FlappingConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultFlappingConfig
+	type plain FlappingConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Window <= 0 || c.Threshold <= 0 {
+		return fmt.Errorf("flapping window and threshold must be positive")
+	}
+	return nil
+}
+
+// Enabled returns whether any correlation is configured.
+func (c Config) Enabled() bool {
+	return c.Burst != nil || c.Flapping != nil
+}
@@ -0,0 +1,271 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package correlation
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/nabokihms/events_exporter/pkg/kube"
+	"github.com/nabokihms/events_exporter/pkg/logging"
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+// pruneInterval is how often occurrences out of windows are forgotten on observations, so they do not pile up
+// without scrapes.
+const pruneInterval = time.Minute
+
+var (
+	burstDesc = prometheus.NewDesc(
+		"kube_event_burst",
+		"Events of the object with the same reason in the burst window, exposed over the burst threshold",
+		[]string{"involved_kind", "involved_namespace", "involved_name", "reason"}, nil,
+	)
+	flappingDesc = prometheus.NewDesc(
+		"kube_event_flapping_score",
+		"Changes of event reasons of the object in the flapping window, exposed over the flapping threshold",
+		[]string{"involved_kind", "involved_namespace", "involved_name"}, nil,
+	)
+)
+
+// objectKey identifies the involved object of events.
+type objectKey struct {
+	kind, namespace, name string
+}
+
+// occurrence is one or more occurrences of the event at the same time.
+type occurrence struct {
+	at        time.Time
+	eventType string
+	reason    string
+	count     int
+}
+
+// seenEvent is the last observed count of the event, so relisted and resynced events are not counted again.
+type seenEvent struct {
+	count float64
+	at    time.Time
+}
+
+// Correlator keeps recent event occurrences by involved objects and exposes bursts and flapping objects
+// as metrics. Windows are evaluated on scrape.
+type Correlator struct {
+	config Config
+	window time.Duration
+	now    func() time.Time
+
+	mu       sync.Mutex
+	seen     map[string]seenEvent
+	objects  map[objectKey][]occurrence
+	prunedAt time.Time
+}
+
+// NewCorrelator returns the correlator for the config.
+func NewCorrelator(config Config) *Correlator {
+	c := &Correlator{
+		config:  config,
+		now:     time.Now,
+		seen:    make(map[string]seenEvent),
+		objects: make(map[objectKey][]occurrence),
+	}
+	if config.Burst != nil {
+		c.window = config.Burst.Window
+	}
+	if config.Flapping != nil && config.Flapping.Window > c.window {
+		c.window = config.Flapping.Window
+	}
+	return c
+}
+
+// Handle observes the event, it can be passed to the event callback as a listener.
+func (c *Correlator) Handle(event *v1.Event) {
+	c.Observe(kube.EventToSample(event, true))
+}
+
+// Observe records new occurrences of the event sample. Samples are expected to have labels of kube.EventMapping.
+func (c *Correlator) Observe(sample vault.Sample) {
+	key := objectKey{
+		kind:      sample.Labels["involved_kind"],
+		namespace: sample.Labels["involved_namespace"],
+		name:      sample.Labels["involved_name"],
+	}
+	if key.name == "" {
+		return
+	}
+
+	now := c.now()
+	at := sample.Timestamp
+	if at.IsZero() {
+		at = now
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.prunedAt) >= pruneInterval {
+		c.prune(now)
+	}
+
+	count := 1
+	if sample.ID != "" {
+		previous, ok := c.seen[sample.ID]
+		switch {
+		case ok && sample.Value <= previous.count:
+			// Nothing happened since the last time, e.g., the informer resync.
+			return
+		case ok:
+			count = int(sample.Value - previous.count)
+		case !sample.FirstTimestamp.IsZero() && at.Sub(sample.FirstTimestamp) <= c.window && sample.Value > 1:
+			// All occurrences of the new event happened within the window.
+			count = int(sample.Value)
+		}
+		c.seen[sample.ID] = seenEvent{count: sample.Value, at: now}
+	}
+
+	if at.Before(now.Add(-c.window)) {
+		return
+	}
+	c.objects[key] = append(c.objects[key], occurrence{
+		at:        at,
+		eventType: sample.Labels["type"],
+		reason:    sample.Labels["reason"],
+		count:     count,
+	})
+}
+
+// Describe implements prometheus.Collector.
+func (c *Correlator) Describe(ch chan<- *prometheus.Desc) {
+	if c.config.Burst != nil {
+		ch <- burstDesc
+	}
+	if c.config.Flapping != nil {
+		ch <- flappingDesc
+	}
+}
+
+// Collect implements prometheus.Collector. Occurrences out of windows are forgotten. Metrics are sent after
+// the lock is released, so a slow scrape does not block observing events.
+func (c *Correlator) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range c.metrics() {
+		ch <- metric
+	}
+}
+
+func (c *Correlator) metrics() []prometheus.Metric {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.prune(now)
+
+	var metrics []prometheus.Metric
+	add := func(desc *prometheus.Desc, value int, labelValues ...string) {
+		metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, float64(value), labelValues...)
+		if err != nil {
+			logging.Logger("correlation").Error(err, "prepare metric", "involved_kind", labelValues[0],
+				"involved_namespace", labelValues[1], "involved_name", labelValues[2])
+			return
+		}
+		metrics = append(metrics, metric)
+	}
+
+	for key, occurrences := range c.objects {
+		if burst := c.config.Burst; burst != nil {
+			for reason, count := range bursts(occurrences, now.Add(-burst.Window), burst.Types) {
+				if count >= burst.Threshold {
+					add(burstDesc, count, key.kind, key.namespace, key.name, reason)
+				}
+			}
+		}
+
+		if flapping := c.config.Flapping; flapping != nil {
+			if score := flappingScore(occurrences, now.Add(-flapping.Window), flapping.Reasons); score >= flapping.Threshold {
+				add(flappingDesc, score, key.kind, key.namespace, key.name)
+			}
+		}
+	}
+	return metrics
+}
+
+func (c *Correlator) prune(now time.Time) {
+	c.prunedAt = now
+	since := now.Add(-c.window)
+	for key, occurrences := range c.objects {
+		recent := occurrences[:0]
+		for _, o := range occurrences {
+			if !o.at.Before(since) {
+				recent = append(recent, o)
+			}
+		}
+		if len(recent) == 0 {
+			delete(c.objects, key)
+			continue
+		}
+		c.objects[key] = recent
+	}
+
+	for id, seen := range c.seen {
+		if seen.at.Before(since) {
+			delete(c.seen, id)
+		}
+	}
+}
+
+// bursts counts occurrences since the time by reasons.
+func bursts(occurrences []occurrence, since time.Time, types []string) map[string]int {
+	counts := make(map[string]int)
+	for _, o := range occurrences {
+		if !o.at.Before(since) && contains(types, o.eventType) {
+			counts[o.reason] += o.count
+		}
+	}
+	return counts
+}
+
+// flappingScore counts changes of the reason between consecutive occurrences since the time.
+func flappingScore(occurrences []occurrence, since time.Time, reasons []string) int {
+	var recent []occurrence
+	for _, o := range occurrences {
+		if !o.at.Before(since) && contains(reasons, o.reason) {
+			recent = append(recent, o)
+		}
+	}
+	// Relisted events are not ordered.
+	sort.SliceStable(recent, func(i, j int) bool { return recent[i].at.Before(recent[j].at) })
+
+	score := 0
+	for i := 1; i < len(recent); i++ {
+		if recent[i].reason != recent[i-1].reason {
+			score++
+		}
+	}
+	return score
+}
+
+// contains returns true for empty lists, they mean no filter.
+func contains(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package correlation
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var baseTime = time.Unix(1600000000, 0)
+
+func event(uid, eventType, reason string, count int32, last time.Time) *v1.Event {
+	return &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{UID: types.UID(uid)},
+		Type:           eventType,
+		Reason:         reason,
+		Count:          count,
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "app"},
+		FirstTimestamp: metav1.NewTime(baseTime.Add(-time.Hour)),
+		LastTimestamp:  metav1.NewTime(last),
+	}
+}
+
+func newTestCorrelator(t *testing.T, config string) (*Correlator, *time.Time) {
+	var c Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(config), &c))
+
+	now := baseTime
+	correlator := NewCorrelator(c)
+	correlator.now = func() time.Time { return now }
+	return correlator, &now
+}
+
+func TestBurst(t *testing.T) {
+	correlator, now := newTestCorrelator(t, `{burst: {window: 10m, threshold: 3}}`)
+
+	correlator.Handle(event("uid-1", "Warning", "BackOff", 1, baseTime.Add(-time.Minute)))
+	correlator.Handle(event("uid-1", "Warning", "BackOff", 2, baseTime))
+	// The informer resync delivers the same event again.
+	correlator.Handle(event("uid-1", "Warning", "BackOff", 2, baseTime))
+	require.NoError(t, testutil.CollectAndCompare(correlator, strings.NewReader("")))
+
+	correlator.Handle(event("uid-1", "Warning", "BackOff", 4, baseTime))
+	// Normal events are not counted by default.
+	correlator.Handle(event("uid-2", "Normal", "BackOff", 10, baseTime))
+	require.NoError(t, testutil.CollectAndCompare(correlator, strings.NewReader(`
+# HELP kube_event_burst Events of the object with the same reason in the burst window, exposed over the burst threshold
+# TYPE kube_event_burst gauge
+kube_event_burst{involved_kind="Pod",involved_name="app",involved_namespace="default",reason="BackOff"} 4
+`)))
+
+	// The burst is over once occurrences leave the window.
+	*now = baseTime.Add(10*time.Minute - time.Second)
+	require.Equal(t, 1, testutil.CollectAndCount(correlator))
+	*now = baseTime.Add(11 * time.Minute)
+	require.Equal(t, 0, testutil.CollectAndCount(correlator))
+	require.Empty(t, correlator.objects)
+}
+
+func TestBurstOfNewEvent(t *testing.T) {
+	correlator, _ := newTestCorrelator(t, `{burst: {window: 10m, threshold: 5}}`)
+
+	// The event seen for the first time happened 5 times within the window.
+	e := event("uid-1", "Warning", "FailedMount", 5, baseTime)
+	e.FirstTimestamp = metav1.NewTime(baseTime.Add(-5 * time.Minute))
+	correlator.Handle(e)
+	require.Equal(t, 1, testutil.CollectAndCount(correlator))
+
+	// The old event is counted once, its earlier occurrences are out of the window.
+	correlator, _ = newTestCorrelator(t, `{burst: {window: 10m, threshold: 5}}`)
+	correlator.Handle(event("uid-1", "Warning", "FailedMount", 50, baseTime))
+	require.Equal(t, 0, testutil.CollectAndCount(correlator))
+}
+
+func TestFlapping(t *testing.T) {
+	correlator, _ := newTestCorrelator(t, `{flapping: {window: 30m, threshold: 3, reasons: [Started, BackOff]}}`)
+
+	correlator.Handle(event("started", "Normal", "Started", 1, baseTime.Add(-20*time.Minute)))
+	correlator.Handle(event("backoff", "Warning", "BackOff", 1, baseTime.Add(-15*time.Minute)))
+	correlator.Handle(event("pulled", "Normal", "Pulled", 1, baseTime.Add(-12*time.Minute)))
+	correlator.Handle(event("started", "Normal", "Started", 2, baseTime.Add(-10*time.Minute)))
+	require.Equal(t, 0, testutil.CollectAndCount(correlator))
+
+	// Pulled events are ignored, and out of order events are sorted.
+	correlator.Handle(event("backoff", "Warning", "BackOff", 2, baseTime.Add(-5*time.Minute)))
+	correlator.Handle(event("late", "Warning", "BackOff", 1, baseTime.Add(-17*time.Minute)))
+	require.NoError(t, testutil.CollectAndCompare(correlator, strings.NewReader(`
+# HELP kube_event_flapping_score Changes of event reasons of the object in the flapping window, exposed over the flapping threshold
+# TYPE kube_event_flapping_score gauge
+kube_event_flapping_score{involved_kind="Pod",involved_name="app",involved_namespace="default"} 3
+`)))
+}
+
+func TestConfig(t *testing.T) {
+	var c Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(`{burst: {}, flapping: {threshold: 2}}`), &c))
+	require.Equal(t, DefaultBurstConfig, *c.Burst)
+	require.Equal(t, 2, c.Flapping.Threshold)
+	require.Equal(t, DefaultFlappingConfig.Window, c.Flapping.Window)
+	require.True(t, c.Enabled())
+
+	require.Error(t, yaml.UnmarshalStrict([]byte(`{burst: {window: -1m}}`), &c))
+	require.False(t, Config{}.Enabled())
+}
+
+func TestPruneWithoutScrapes(t *testing.T) {
+	correlator, now := newTestCorrelator(t, `{burst: {window: 10m, threshold: 3}}`)
+
+	correlator.Handle(event("uid-1", "Warning", "BackOff", 1, baseTime))
+	*now = baseTime.Add(11 * time.Minute)
+	e := event("uid-2", "Warning", "BackOff", 1, *now)
+	e.InvolvedObject.Name = "other"
+	correlator.Handle(e)
+
+	require.Len(t, correlator.objects, 1)
+	require.Contains(t, correlator.objects, objectKey{kind: "Pod", namespace: "default", name: "other"})
+	require.Len(t, correlator.seen, 1)
+}
+
+func TestInvalidLabelValues(t *testing.T) {
+	correlator, _ := newTestCorrelator(t, `{burst: {window: 10m, threshold: 1}}`)
+
+	e := event("uid-1", "Warning", "BackOff", 1, baseTime)
+	e.InvolvedObject.Name = "app\xd0"
+	correlator.Handle(e)
+	correlator.Handle(event("uid-2", "Warning", "BackOff", 1, baseTime))
+
+	// The invalid series is skipped without failing the whole scrape.
+	require.Equal(t, 1, testutil.CollectAndCount(correlator))
+}
@@ -0,0 +1,469 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventmetric
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/nabokihms/events_exporter/pkg/kube"
+	"github.com/nabokihms/events_exporter/pkg/logging"
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+const (
+	defaultResyncPeriod   = 10 * time.Minute
+	defaultStatusInterval = 30 * time.Second
+)
+
+// Controller registers vault mappings declared by EventMetric and ClusterEventMetric resources at runtime,
+// stores events matching their filters and reports the state of every resource in its status.
+type Controller struct {
+	client    dynamic.Interface
+	factory   dynamicinformer.DynamicSharedInformerFactory
+	vault     *vault.MetricsVault
+	converter *kube.EventConverter
+	template  vault.Mapping
+
+	statusInterval time.Duration
+
+	mu sync.RWMutex
+	// metrics are registered metrics by keys of their resources.
+	metrics map[string]*eventMetric
+	// owners are keys of resources by names of registered metrics.
+	owners map[string]string
+	// pending are resources waiting for their metric names to be released by other resources.
+	pending map[string]*unstructured.Unstructured
+}
+
+type eventMetric struct {
+	resource schema.GroupVersionResource
+	object   *unstructured.Unstructured
+	mapping  vault.Mapping
+	filter   filter
+}
+
+// NewController creates the controller of events metrics. Declared mappings are based on the template, e.g., with
+// the TTL and the series limit of events, and samples are converted by the converter of events.
+func NewController(
+	client dynamic.Interface,
+	metricsVault *vault.MetricsVault,
+	converter *kube.EventConverter,
+	template vault.Mapping,
+) *Controller {
+	return &Controller{
+		client:         client,
+		factory:        dynamicinformer.NewDynamicSharedInformerFactory(client, defaultResyncPeriod),
+		vault:          metricsVault,
+		converter:      converter,
+		template:       template,
+		statusInterval: defaultStatusInterval,
+		metrics:        make(map[string]*eventMetric),
+		owners:         make(map[string]string),
+		pending:        make(map[string]*unstructured.Unstructured),
+	}
+}
+
+// Run starts informers of resources and waits for the first cache synchronization, so metrics are registered
+// before events are listed. Statuses are refreshed in the background until the stop channel is closed.
+func (c *Controller) Run(stopCh <-chan struct{}, errorCh chan<- error) {
+	for _, resource := range []schema.GroupVersionResource{EventMetricsResource, ClusterEventMetricsResource} {
+		resource := resource
+		if err := c.checkAccess(resource); err != nil {
+			errorCh <- fmt.Errorf("list %s: %w", resource.Resource, err)
+			return
+		}
+
+		informer := c.factory.ForResource(resource).Informer()
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				c.apply(obj.(*unstructured.Unstructured))
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				c.apply(obj.(*unstructured.Unstructured))
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				c.remove(obj.(*unstructured.Unstructured))
+			},
+		})
+		err := informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			// The reflector retries with backoff on its own.
+			logging.Logger("eventmetric").Info("watch failed, retrying", "resource", resource.Resource, "error", err)
+		})
+		if err != nil {
+			errorCh <- fmt.Errorf("set %s watch handler: %w", resource.Resource, err)
+		}
+	}
+
+	c.factory.Start(stopCh)
+	for resource, synced := range c.factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			errorCh <- fmt.Errorf("%s informer cache is not synced", resource.Resource)
+			return
+		}
+	}
+
+	go wait.Until(c.refreshStatuses, c.statusInterval, stopCh)
+}
+
+// checkAccess lists the resource once to report errors that informers would retry forever, e.g., missing
+// permissions. Reflectors do not keep types of list errors, so they cannot be told apart in the watch error handler.
+// Missing resources are not fatal, CRDs may be installed after the exporter.
+func (c *Controller) checkAccess(resource schema.GroupVersionResource) error {
+	_, err := c.client.Resource(resource).List(context.Background(), metav1.ListOptions{Limit: 1})
+	if err != nil && kube.IsFatalError(err) && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Handle stores the event to all metrics with matching filters. It is the listener of events.
+func (c *Controller) Handle(event *v1.Event) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.metrics) == 0 {
+		return
+	}
+	sample, keep := c.converter.Convert(event)
+	if !keep {
+		return
+	}
+
+	for _, metric := range c.metrics {
+		if !metric.filter.matches(event) {
+			continue
+		}
+		kube.StoreSample(c.vault, metric.mapping.Name, metric.sample(sample))
+	}
+}
+
+// sample keeps only labels of the metric in the sample of the event.
+func (m *eventMetric) sample(sample vault.Sample) vault.Sample {
+	labels := make(prometheus.Labels, len(m.mapping.LabelNames))
+	for _, name := range m.mapping.LabelNames {
+		if value, ok := sample.Labels[name]; ok {
+			labels[name] = value
+		}
+	}
+	sample.Labels = labels
+	return sample
+}
+
+func (c *Controller) apply(obj *unstructured.Unstructured) {
+	resource := resourceOf(obj)
+	metric, err := c.compile(obj)
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, resourceKey(obj))
+		c.unregister(resourceKey(obj))
+		c.mu.Unlock()
+
+		c.updateStatus(resource, obj, metav1.Condition{Status: metav1.ConditionFalse, Reason: ReasonInvalidSpec, Message: err.Error()})
+		return
+	}
+	metric.resource = resource
+
+	c.updateStatus(resource, obj, c.register(metric))
+}
+
+// register replaces the metric of the resource in the vault and returns the ready condition of the resource.
+func (c *Controller) register(metric *eventMetric) metav1.Condition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := resourceKey(metric.object)
+	delete(c.pending, key)
+
+	if owner, ok := c.owners[metric.mapping.Name]; ok && owner != key {
+		c.unregister(key)
+		c.pending[key] = metric.object
+		return metav1.Condition{
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonMetricConflict,
+			Message: fmt.Sprintf("metric %q is declared by %s", metric.mapping.Name, owner),
+		}
+	}
+
+	// Series are kept if only the filter is changed.
+	if current, ok := c.metrics[key]; !ok || !reflect.DeepEqual(current.mapping, metric.mapping) {
+		c.unregister(key)
+		if err := c.vault.RegisterMappings([]vault.Mapping{metric.mapping}); err != nil {
+			return metav1.Condition{Status: metav1.ConditionFalse, Reason: ReasonRegistrationFailed, Message: err.Error()}
+		}
+	}
+
+	c.metrics[key] = metric
+	c.owners[metric.mapping.Name] = key
+	return readyCondition()
+}
+
+// unregister removes the metric of the resource from the vault. The lock must be held.
+func (c *Controller) unregister(key string) {
+	metric, ok := c.metrics[key]
+	if !ok {
+		return
+	}
+	if _, err := c.vault.UnregisterMapping(metric.mapping.Name); err != nil {
+		logging.Logger("eventmetric").Error(err, "unregister metric", "resource", key)
+	}
+	delete(c.metrics, key)
+	delete(c.owners, metric.mapping.Name)
+}
+
+func (c *Controller) remove(obj *unstructured.Unstructured) {
+	c.mu.Lock()
+	key := resourceKey(obj)
+	delete(c.pending, key)
+	c.unregister(key)
+
+	// Resources waiting for the released metric name are applied again in a stable order.
+	keys := make([]string, 0, len(c.pending))
+	for pendingKey := range c.pending {
+		keys = append(keys, pendingKey)
+	}
+	sort.Strings(keys)
+	pending := make([]*unstructured.Unstructured, 0, len(keys))
+	for _, pendingKey := range keys {
+		pending = append(pending, c.pending[pendingKey])
+	}
+	c.mu.Unlock()
+
+	for _, pendingObj := range pending {
+		c.apply(pendingObj)
+	}
+}
+
+// compile converts the resource into the metric, or returns the error describing the invalid spec.
+func (c *Controller) compile(obj *unstructured.Unstructured) (*eventMetric, error) {
+	var resource EventMetric
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &resource); err != nil {
+		return nil, fmt.Errorf("decode spec: %w", err)
+	}
+	spec := resource.Spec
+
+	if spec.MetricName == "" {
+		return nil, errors.New("metricName is required")
+	}
+
+	mapping := c.template
+	mapping.Name = spec.MetricName
+	mapping.Help = spec.Help
+	if mapping.Help == "" {
+		mapping.Help = fmt.Sprintf("Kubernetes events collected by %s %s", obj.GetKind(), objectName(obj))
+	}
+	mapping.Type = spec.Type
+
+	mapping.LabelNames = c.converter.LabelNames()
+	if len(spec.Labels) > 0 {
+		known := sets.NewString(mapping.LabelNames...)
+		for _, name := range spec.Labels {
+			if !known.Has(name) {
+				return nil, fmt.Errorf("unknown label %q, events have labels: %v", name, mapping.LabelNames)
+			}
+		}
+		mapping.LabelNames = spec.Labels
+	}
+
+	if spec.TTL != nil {
+		if spec.TTL.Duration <= 0 {
+			return nil, fmt.Errorf("ttl must be positive, got %v", spec.TTL.Duration)
+		}
+		mapping.TTL = spec.TTL.Duration
+	}
+
+	if err := mapping.Validate(); err != nil {
+		return nil, err
+	}
+
+	namespaces := spec.Filter.Namespaces
+	if namespace := obj.GetNamespace(); namespace != "" {
+		if len(namespaces) > 0 {
+			return nil, fmt.Errorf("filter.namespaces are only allowed for %s", ClusterEventMetricKind)
+		}
+		namespaces = []string{namespace}
+	}
+
+	return &eventMetric{
+		object:  obj,
+		mapping: mapping,
+		filter: filter{
+			namespaces: sets.NewString(namespaces...),
+			types:      sets.NewString(spec.Filter.Types...),
+			reasons:    sets.NewString(spec.Filter.Reasons...),
+			kinds:      sets.NewString(spec.Filter.InvolvedKinds...),
+		},
+	}, nil
+}
+
+// refreshStatuses reports current numbers of series of registered metrics.
+func (c *Controller) refreshStatuses() {
+	c.mu.RLock()
+	metrics := make([]*eventMetric, 0, len(c.metrics))
+	for _, metric := range c.metrics {
+		metrics = append(metrics, metric)
+	}
+	c.mu.RUnlock()
+
+	for _, metric := range metrics {
+		obj, err := c.get(metric.resource, metric.object)
+		if err != nil {
+			logging.Logger("eventmetric").Error(err, "get resource", "resource", resourceKey(metric.object))
+			continue
+		}
+		c.updateStatus(metric.resource, obj, readyCondition())
+	}
+}
+
+// get returns the latest version of the resource from the informer cache.
+func (c *Controller) get(resource schema.GroupVersionResource, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	lister := c.factory.ForResource(resource).Lister()
+
+	var (
+		latest runtime.Object
+		err    error
+	)
+	if namespace := obj.GetNamespace(); namespace != "" {
+		latest, err = lister.ByNamespace(namespace).Get(obj.GetName())
+	} else {
+		latest, err = lister.Get(obj.GetName())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return latest.(*unstructured.Unstructured), nil
+}
+
+// updateStatus sets the ready condition and the number of series in the status of the resource. The status is not
+// updated if nothing changed, so updates of the resource caused by the controller do not trigger new updates.
+func (c *Controller) updateStatus(resource schema.GroupVersionResource, obj *unstructured.Unstructured, condition metav1.Condition) {
+	var current EventMetric
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &current); err != nil {
+		// The status is overwritten if it cannot be decoded.
+		current.Status = EventMetricStatus{}
+	}
+
+	status := EventMetricStatus{
+		ObservedGeneration: obj.GetGeneration(),
+		Conditions:         append([]metav1.Condition(nil), current.Status.Conditions...),
+	}
+	if condition.Status == metav1.ConditionTrue {
+		status.Series = c.series(resourceKey(obj))
+	}
+	condition.Type = ReadyCondition
+	condition.ObservedGeneration = obj.GetGeneration()
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	if equality.Semantic.DeepEqual(current.Status, status) {
+		return
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		logging.Logger("eventmetric").Error(err, "encode status", "resource", resourceKey(obj))
+		return
+	}
+	updated := obj.DeepCopy()
+	updated.Object["status"] = content
+
+	client := c.client.Resource(resource).Namespace(obj.GetNamespace())
+	_, err = client.UpdateStatus(context.TODO(), updated, metav1.UpdateOptions{})
+	switch {
+	case apierrors.IsConflict(err), apierrors.IsNotFound(err):
+		// The resource is changed or deleted, and the controller will handle it again.
+		logging.Logger("eventmetric").V(1).Info("update status", "resource", resourceKey(obj), "reason", err.Error())
+	case err != nil:
+		logging.Logger("eventmetric").Error(err, "update status", "resource", resourceKey(obj))
+	}
+}
+
+func (c *Controller) series(key string) int {
+	c.mu.RLock()
+	metric, ok := c.metrics[key]
+	c.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+
+	for _, status := range c.vault.MappingsStatus() {
+		if status.Name == metric.mapping.Name {
+			return status.Series
+		}
+	}
+	return 0
+}
+
+func readyCondition() metav1.Condition {
+	return metav1.Condition{Status: metav1.ConditionTrue, Reason: ReasonRegistered, Message: "metric is registered"}
+}
+
+// resourceKey identifies resources of both kinds, e.g., EventMetric/namespace/name.
+func resourceKey(obj *unstructured.Unstructured) string {
+	return obj.GetKind() + "/" + objectName(obj)
+}
+
+func objectName(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+func resourceOf(obj *unstructured.Unstructured) schema.GroupVersionResource {
+	if obj.GetKind() == ClusterEventMetricKind {
+		return ClusterEventMetricsResource
+	}
+	return EventMetricsResource
+}
+
+type filter struct {
+	namespaces sets.String
+	types      sets.String
+	reasons    sets.String
+	kinds      sets.String
+}
+
+func (f filter) matches(event *v1.Event) bool {
+	return matchesAny(f.namespaces, event.Namespace) &&
+		matchesAny(f.types, event.Type) &&
+		matchesAny(f.reasons, event.Reason) &&
+		matchesAny(f.kinds, event.InvolvedObject.Kind)
+}
+
+func matchesAny(values sets.String, value string) bool {
+	return values.Len() == 0 || values.Has(value)
+}
@@ -0,0 +1,313 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventmetric
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/nabokihms/events_exporter/pkg/kube"
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+func newResource(kind, namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": Group + "/" + Version,
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       spec,
+	}}
+	obj.SetNamespace(namespace)
+	return obj
+}
+
+func newFakeClient(objects ...runtime.Object) *fake.FakeDynamicClient {
+	return fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		EventMetricsResource:        "EventMetricList",
+		ClusterEventMetricsResource: "ClusterEventMetricList",
+	}, objects...)
+}
+
+func getStatus(t *testing.T, client *fake.FakeDynamicClient, resource schema.GroupVersionResource, namespace, name string) EventMetricStatus {
+	t.Helper()
+	obj, err := client.Resource(resource).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	var metric EventMetric
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &metric))
+	return metric.Status
+}
+
+func readyReason(status EventMetricStatus) string {
+	for _, condition := range status.Conditions {
+		if condition.Type == ReadyCondition {
+			return condition.Reason
+		}
+	}
+	return ""
+}
+
+func registeredMetrics(v *vault.MetricsVault) []string {
+	var names []string
+	for _, status := range v.MappingsStatus() {
+		names = append(names, status.Name)
+	}
+	return names
+}
+
+func TestController(t *testing.T) {
+	client := newFakeClient(
+		newResource(EventMetricKind, "team-a", "oom", map[string]interface{}{
+			"metricName": "team_a_oom_events",
+			"filter":     map[string]interface{}{"reasons": []interface{}{"OOMKilling"}},
+			"labels":     []interface{}{"involved_name", "reason"},
+			"ttl":        "10m",
+		}),
+		newResource(EventMetricKind, "team-a", "invalid", map[string]interface{}{
+			"metricName": "team-a-events",
+		}),
+		newResource(ClusterEventMetricKind, "", "backoffs", map[string]interface{}{
+			"metricName": "backoff_events",
+			"type":       "counter",
+			"filter": map[string]interface{}{
+				"reasons":    []interface{}{"BackOff"},
+				"namespaces": []interface{}{"team-a", "team-b"},
+			},
+		}),
+	)
+
+	metricsVault := vault.NewVault()
+	require.NoError(t, metricsVault.RegisterMappings([]vault.Mapping{kube.EventMapping(time.Hour)}))
+
+	controller := NewController(client, metricsVault, &kube.EventConverter{OmitMessages: true}, vault.Mapping{TTL: time.Hour})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	controller.Run(stopCh, make(chan error, 10))
+
+	require.Eventually(t, func() bool {
+		return len(registeredMetrics(metricsVault)) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"backoff_events", "kube_event_info", "team_a_oom_events"}, registeredMetrics(metricsVault))
+
+	for _, event := range []*v1.Event{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", UID: "oom"}, Reason: "OOMKilling", Count: 1, InvolvedObject: v1.ObjectReference{Kind: "Node", Name: "node-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "team-c", UID: "other-oom"}, Reason: "OOMKilling", Count: 1},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", UID: "backoff"}, Reason: "BackOff", Count: 3, InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "app"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "team-c", UID: "other-backoff"}, Reason: "BackOff", Count: 1},
+	} {
+		controller.Handle(event)
+	}
+
+	samples := make(map[string]vault.StoredSample)
+	for _, sample := range metricsVault.Samples() {
+		samples[sample.Metric] = sample
+	}
+	require.Len(t, samples, 2)
+
+	require.Equal(t, "oom", samples["team_a_oom_events"].ID)
+	require.Equal(t, map[string]string{"involved_name": "node-1", "reason": "OOMKilling"}, samples["team_a_oom_events"].Labels)
+	require.Equal(t, 10*time.Minute, samples["team_a_oom_events"].TTL)
+
+	require.Equal(t, "backoff", samples["backoff_events"].ID)
+	require.Equal(t, float64(3), samples["backoff_events"].Value)
+	require.Equal(t, "app", samples["backoff_events"].Labels["involved_name"])
+	require.Equal(t, time.Hour, samples["backoff_events"].TTL)
+
+	controller.refreshStatuses()
+
+	status := getStatus(t, client, EventMetricsResource, "team-a", "oom")
+	require.Equal(t, ReasonRegistered, readyReason(status))
+	require.Equal(t, 1, status.Series)
+
+	status = getStatus(t, client, EventMetricsResource, "team-a", "invalid")
+	require.Equal(t, ReasonInvalidSpec, readyReason(status))
+	require.Contains(t, status.Conditions[0].Message, `invalid metric name "team-a-events"`)
+
+	// The metric name is taken, so the resource waits until it is released.
+	duplicate := newResource(EventMetricKind, "team-b", "oom", map[string]interface{}{"metricName": "team_a_oom_events"})
+	_, err := client.Resource(EventMetricsResource).Namespace("team-b").Create(context.Background(), duplicate, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return readyReason(getStatus(t, client, EventMetricsResource, "team-b", "oom")) == ReasonMetricConflict
+	}, 5*time.Second, 10*time.Millisecond)
+
+	err = client.Resource(EventMetricsResource).Namespace("team-a").Delete(context.Background(), "oom", metav1.DeleteOptions{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return readyReason(getStatus(t, client, EventMetricsResource, "team-b", "oom")) == ReasonRegistered
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Series of the deleted resource are removed with its metric.
+	for _, sample := range metricsVault.Samples() {
+		require.NotEqual(t, "team_a_oom_events", sample.Metric)
+	}
+	require.Contains(t, registeredMetrics(metricsVault), "team_a_oom_events")
+}
+
+func TestControllerLabelsChangeWithBoltStorage(t *testing.T) {
+	resource := newResource(EventMetricKind, "default", "oom", map[string]interface{}{
+		"metricName": "oom_events",
+		"labels":     []interface{}{"involved_name"},
+	})
+	client := newFakeClient(resource)
+
+	db, err := vault.OpenBoltDB(filepath.Join(t.TempDir(), "vault.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	metricsVault := vault.NewVaultWithStorage(db.StorageFactory)
+
+	controller := NewController(client, metricsVault, &kube.EventConverter{OmitMessages: true}, vault.Mapping{TTL: time.Hour})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	controller.Run(stopCh, make(chan error, 10))
+
+	require.Eventually(t, func() bool {
+		return len(registeredMetrics(metricsVault)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	event := &v1.Event{ObjectMeta: metav1.ObjectMeta{Namespace: "default", UID: "oom"}, Reason: "OOMKilling", Count: 1, InvolvedObject: v1.ObjectReference{Kind: "Node", Name: "node-1"}}
+	controller.Handle(event)
+	require.Len(t, metricsVault.Samples(), 1)
+
+	resource.Object["spec"].(map[string]interface{})["labels"] = []interface{}{"reason", "involved_name"}
+	_, err = client.Resource(EventMetricsResource).Namespace("default").Update(context.Background(), resource, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		status := metricsVault.MappingsStatus()
+		return len(status) == 1 && len(status[0].LabelNames) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Series stored with old labels are dropped with the bucket instead of being restored with misaligned values.
+	require.Empty(t, metricsVault.Samples())
+
+	controller.Handle(event)
+	samples := metricsVault.Samples()
+	require.Len(t, samples, 1)
+	require.Equal(t, map[string]string{"involved_name": "node-1", "reason": "OOMKilling"}, samples[0].Labels)
+}
+
+func TestControllerWatchErrors(t *testing.T) {
+	client := newFakeClient(newResource(ClusterEventMetricKind, "", "oom", map[string]interface{}{"metricName": "oom_events"}))
+
+	var (
+		mu    sync.Mutex
+		lists int
+	)
+	client.PrependReactor("list", "clustereventmetrics", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lists++
+		if lists <= 2 {
+			return true, nil, apierrors.NewServiceUnavailable("the server is restarting")
+		}
+		return false, nil, nil
+	})
+	// CRDs of namespaced resources are not installed yet.
+	client.PrependReactor("list", "eventmetrics", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if lists < 3 {
+			return true, nil, apierrors.NewNotFound(EventMetricsResource.GroupResource(), "")
+		}
+		return false, nil, nil
+	})
+
+	metricsVault := vault.NewVault()
+	controller := NewController(client, metricsVault, &kube.EventConverter{OmitMessages: true}, vault.Mapping{TTL: time.Hour})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	errorCh := make(chan error, 10)
+
+	// Transient errors are retried by informers instead of stopping the exporter.
+	controller.Run(stopCh, errorCh)
+	require.Equal(t, []string{"oom_events"}, registeredMetrics(metricsVault))
+	require.Len(t, errorCh, 0)
+
+	forbiddenClient := newFakeClient()
+	forbiddenClient.PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(EventMetricsResource.GroupResource(), "", errors.New("no permissions"))
+	})
+	controller = NewController(forbiddenClient, vault.NewVault(), &kube.EventConverter{}, vault.Mapping{TTL: time.Hour})
+	go controller.Run(stopCh, errorCh)
+
+	select {
+	case err := <-errorCh:
+		require.True(t, apierrors.IsForbidden(err))
+	case <-time.After(5 * time.Second):
+		t.Fatal("error was not reported")
+	}
+}
+
+func TestInvalidSpecs(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource *unstructured.Unstructured
+		error    string
+	}{
+		{
+			name:     "no metric name",
+			resource: newResource(EventMetricKind, "default", "test", map[string]interface{}{}),
+			error:    "metricName is required",
+		},
+		{
+			name:     "unknown type",
+			resource: newResource(EventMetricKind, "default", "test", map[string]interface{}{"metricName": "test", "type": "histogram"}),
+			error:    `unknown metric type "histogram"`,
+		},
+		{
+			name:     "unknown label",
+			resource: newResource(EventMetricKind, "default", "test", map[string]interface{}{"metricName": "test", "labels": []interface{}{"pod"}}),
+			error:    `unknown label "pod"`,
+		},
+		{
+			name:     "negative ttl",
+			resource: newResource(EventMetricKind, "default", "test", map[string]interface{}{"metricName": "test", "ttl": "-1m"}),
+			error:    "ttl must be positive",
+		},
+		{
+			name: "namespaces of a namespaced resource",
+			resource: newResource(EventMetricKind, "default", "test", map[string]interface{}{
+				"metricName": "test",
+				"filter":     map[string]interface{}{"namespaces": []interface{}{"kube-system"}},
+			}),
+			error: "filter.namespaces are only allowed for ClusterEventMetric",
+		},
+	}
+
+	controller := NewController(newFakeClient(), vault.NewVault(), &kube.EventConverter{}, vault.Mapping{TTL: time.Hour})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := controller.compile(tt.resource)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.error)
+		})
+	}
+}
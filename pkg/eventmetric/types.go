@@ -0,0 +1,83 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventmetric
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group   = "eventsexporter.nabokihms.io"
+	Version = "v1alpha1"
+
+	EventMetricKind        = "EventMetric"
+	ClusterEventMetricKind = "ClusterEventMetric"
+)
+
+var (
+	EventMetricsResource        = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "eventmetrics"}
+	ClusterEventMetricsResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "clustereventmetrics"}
+)
+
+// ReadyCondition reports whether the metric is registered and collects events.
+const ReadyCondition = "Ready"
+
+// Reasons of the ready condition.
+const (
+	ReasonRegistered         = "Registered"
+	ReasonInvalidSpec        = "InvalidSpec"
+	ReasonMetricConflict     = "MetricConflict"
+	ReasonRegistrationFailed = "RegistrationFailed"
+)
+
+// EventMetric declares a metric of events. EventMetrics are namespaced and only collect events of their own
+// namespace, ClusterEventMetrics have the same spec and collect events of all namespaces.
+type EventMetric struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EventMetricSpec   `json:"spec"`
+	Status EventMetricStatus `json:"status,omitempty"`
+}
+
+type EventMetricSpec struct {
+	// MetricName is the name of the exposed metric. It must be unique across all declared and built-in metrics.
+	MetricName string `json:"metricName"`
+	Help       string `json:"help,omitempty"`
+	// Type is a prometheus metric type, either gauge (default) or counter.
+	Type string `json:"type,omitempty"`
+
+	Filter EventFilter `json:"filter,omitempty"`
+	// Labels are names of event labels the metric has, all labels of events if empty.
+	Labels []string `json:"labels,omitempty"`
+	// TTL is for how long to keep stale series, the TTL of events if empty.
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+}
+
+// EventFilter selects events collected by the metric. Empty fields match all events.
+type EventFilter struct {
+	Types         []string `json:"types,omitempty"`
+	Reasons       []string `json:"reasons,omitempty"`
+	InvolvedKinds []string `json:"involvedKinds,omitempty"`
+	// Namespaces of events, only ClusterEventMetrics can set them.
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+type EventMetricStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Series is the number of series of the metric at the last status update.
+	Series     int                `json:"series"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
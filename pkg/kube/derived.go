@@ -0,0 +1,213 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
+)
+
+// derivedPruneInterval is how often stale series and seen events are forgotten on handling, so they do not pile up
+// without scrapes.
+const derivedPruneInterval = time.Minute
+
+// DerivedMetrics counts events matching rules of enabled rule packs. Counters are kept in memory and start
+// from zero with the exporter, series are removed if they were not updated for the TTL.
+type DerivedMetrics struct {
+	rules []derivedRule
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu sync.Mutex
+	// seen are last counts of events, so resynced and updated events only add new occurrences.
+	seen     map[types.UID]seenCount
+	prunedAt time.Time
+}
+
+type derivedRule struct {
+	DerivedRule
+	desc   *prometheus.Desc
+	series map[string]*derivedSeries
+}
+
+type derivedSeries struct {
+	labelValues []string
+	value       float64
+	lastUpdate  time.Time
+}
+
+type seenCount struct {
+	count      int32
+	lastUpdate time.Time
+}
+
+// NewDerivedMetrics enables built-in rule packs by names.
+func NewDerivedMetrics(packs []string, ttl time.Duration) (*DerivedMetrics, error) {
+	d := &DerivedMetrics{ttl: ttl, now: time.Now, seen: make(map[types.UID]seenCount)}
+
+	for _, name := range packs {
+		pack, ok := rulePack(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown rule pack %q, available packs are %s", name, strings.Join(RulePackNames(), ", "))
+		}
+		for _, rule := range pack.Rules {
+			d.rules = append(d.rules, derivedRule{
+				DerivedRule: rule,
+				desc:        prometheus.NewDesc(rule.Metric, rule.Help, rule.LabelNames, nil),
+				series:      make(map[string]*derivedSeries),
+			})
+		}
+	}
+	return d, nil
+}
+
+// RulePackNames returns names of built-in rule packs.
+func RulePackNames() []string {
+	names := make([]string, 0, len(BuiltinRulePacks))
+	for _, pack := range BuiltinRulePacks {
+		names = append(names, pack.Name)
+	}
+	return names
+}
+
+func rulePack(name string) (RulePack, bool) {
+	for _, pack := range BuiltinRulePacks {
+		if pack.Name == name {
+			return pack, true
+		}
+	}
+	return RulePack{}, false
+}
+
+// Handle counts new occurrences of the event, it can be passed to the event callback as a listener.
+func (d *DerivedMetrics) Handle(event *v1.Event) {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.prunedAt) >= derivedPruneInterval {
+		d.prune(now)
+	}
+
+	added := eventCount(event)
+	if previous, ok := d.seen[event.UID]; ok {
+		added -= previous.count
+	}
+	if added <= 0 {
+		return
+	}
+	if event.UID != "" {
+		d.seen[event.UID] = seenCount{count: eventCount(event), lastUpdate: now}
+	}
+
+	for i := range d.rules {
+		rule := &d.rules[i]
+		if !contains(rule.Reasons, event.Reason) {
+			continue
+		}
+		for _, labelValues := range rule.Labels(event) {
+			key := strings.Join(labelValues, string(rune(0)))
+			series, ok := rule.series[key]
+			if !ok {
+				series = &derivedSeries{labelValues: labelValues}
+				rule.series[key] = series
+			}
+			series.value += float64(added)
+			series.lastUpdate = now
+		}
+	}
+}
+
+// eventCount returns the number of occurrences of the event for both core and events.k8s.io events.
+func eventCount(event *v1.Event) int32 {
+	count := event.Count
+	if event.Series != nil && event.Series.Count > count {
+		count = event.Series.Count
+	}
+	if count < 1 {
+		count = 1
+	}
+	return count
+}
+
+// Describe implements prometheus.Collector.
+func (d *DerivedMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, rule := range d.rules {
+		ch <- rule.desc
+	}
+}
+
+// Collect implements prometheus.Collector. Stale series are removed first. Metrics are sent after the lock is
+// released, so a slow scrape does not block handling of events.
+func (d *DerivedMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range d.metrics() {
+		ch <- metric
+	}
+}
+
+func (d *DerivedMetrics) metrics() []prometheus.Metric {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.prune(d.now())
+
+	var metrics []prometheus.Metric
+	for _, rule := range d.rules {
+		for _, series := range rule.series {
+			metric, err := prometheus.NewConstMetric(rule.desc, prometheus.CounterValue, series.value, series.labelValues...)
+			if err != nil {
+				logging.Logger("kube").Error(err, "prepare derived metric", "metric", rule.Metric)
+				continue
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics
+}
+
+// prune removes series and seen events not updated for the TTL. The lock must be held.
+func (d *DerivedMetrics) prune(now time.Time) {
+	d.prunedAt = now
+	staleBefore := now.Add(-d.ttl)
+	for uid, seen := range d.seen {
+		if seen.lastUpdate.Before(staleBefore) {
+			delete(d.seen, uid)
+		}
+	}
+	for _, rule := range d.rules {
+		for key, series := range rule.series {
+			if series.lastUpdate.Before(staleBefore) {
+				delete(rule.series, key)
+			}
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func podEvent(reason, message, fieldPath string) *v1.Event {
+	return &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{UID: "event-uid"},
+		Reason:         reason,
+		Message:        message,
+		Count:          1,
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "app", FieldPath: fieldPath},
+	}
+}
+
+func TestRulePacks(t *testing.T) {
+	tests := []struct {
+		pack     string
+		event    *v1.Event
+		expected string
+	}{
+		{
+			pack:     "scheduling",
+			event:    podEvent("FailedScheduling", "0/5 nodes are available: 2 Insufficient cpu, 3 node(s) had untolerated taint {node-role.kubernetes.io/control-plane: }. preemption: 0/5 nodes are available: 5 Preemption is not helpful for scheduling.", ""),
+			expected: `kube_pod_scheduling_failures_total{namespace="default",reason_detail="Insufficient cpu"} 1` + "\n" + `kube_pod_scheduling_failures_total{namespace="default",reason_detail="node(s) had untolerated taint"} 1`,
+		},
+		{
+			pack:     "scheduling",
+			event:    podEvent("FailedScheduling", `persistentvolumeclaim "data" not found.`, ""),
+			expected: `kube_pod_scheduling_failures_total{namespace="default",reason_detail="persistentvolumeclaim not found"} 1`,
+		},
+		{
+			pack:     "oom",
+			event:    podEvent("OOMKilling", "Container killed", "spec.containers{java}"),
+			expected: `kube_pod_oom_events_total{container="java",namespace="default",pod="app"} 1`,
+		},
+		{
+			pack: "oom",
+			event: &v1.Event{
+				Reason:         "OOMKilling",
+				Message:        "Memory cgroup out of memory: Killed process 2322 (stress) total-vm:1234kB, anon-rss:1000kB",
+				InvolvedObject: v1.ObjectReference{Kind: "Node", Name: "node-1"},
+			},
+			expected: `kube_pod_oom_events_total{container="stress",namespace="",pod=""} 1`,
+		},
+		{
+			pack:     "eviction",
+			event:    podEvent("Evicted", "The node was low on resource: ephemeral-storage. Threshold quantity: 1Gi, available: 500Mi.", ""),
+			expected: `kube_pod_evictions_total{namespace="default",resource="ephemeral-storage"} 1`,
+		},
+		{
+			pack:     "image",
+			event:    podEvent("Failed", `Failed to pull image "nginx:latst": rpc error: code = NotFound`, "spec.containers{nginx}"),
+			expected: `kube_image_pull_failures_total{image="nginx:latst"} 1`,
+		},
+		{
+			pack:  "image",
+			event: podEvent("Failed", "Error: ErrImagePull", "spec.containers{nginx}"),
+		},
+		{
+			pack:     "probes",
+			event:    podEvent("Unhealthy", "Readiness probe failed: HTTP probe failed with statuscode: 503", "spec.containers{web}"),
+			expected: `kube_pod_probe_failures_total{container="web",namespace="default",pod="app",probe="readiness"} 1`,
+		},
+		{
+			pack:     "restarts",
+			event:    podEvent("BackOff", "Back-off restarting failed container worker in pod app_default(uid)", ""),
+			expected: `kube_pod_container_restart_backoffs_total{container="worker",namespace="default",pod="app"} 1`,
+		},
+		{
+			pack:  "restarts",
+			event: podEvent("BackOff", `Back-off pulling image "nginx:latst"`, "spec.containers{nginx}"),
+		},
+		{
+			pack:     "volumes",
+			event:    podEvent("FailedMount", "Unable to attach or mount volumes: unmounted volumes=[config data], unattached volumes=[config data token]: timed out", ""),
+			expected: `kube_pod_volume_mount_failures_total{namespace="default",volume="config"} 1` + "\n" + `kube_pod_volume_mount_failures_total{namespace="default",volume="data"} 1`,
+		},
+		{
+			pack:     "volumes",
+			event:    podEvent("FailedMount", `MountVolume.SetUp failed for volume "secret" : secret "secret" not found`, ""),
+			expected: `kube_pod_volume_mount_failures_total{namespace="default",volume="secret"} 1`,
+		},
+		{
+			pack: "nodes",
+			event: &v1.Event{
+				Reason:         "NodeNotReady",
+				Message:        "Node node-1 status is now: NodeNotReady",
+				InvolvedObject: v1.ObjectReference{Kind: "Node", Name: "node-1"},
+			},
+			expected: `kube_node_not_ready_events_total{node="node-1"} 1`,
+		},
+		{
+			pack:  "nodes",
+			event: podEvent("NodeNotReady", "Node is not ready", ""),
+		},
+		{
+			pack:     "sandbox",
+			event:    podEvent("FailedCreatePodSandBox", `Failed to create pod sandbox: rpc error: code = Unknown desc = failed to setup network for sandbox "abc": plugin type="calico" failed`, ""),
+			expected: `kube_pod_sandbox_failures_total{cause="network",namespace="default"} 1`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pack+"/"+tt.event.Message, func(t *testing.T) {
+			derived, err := NewDerivedMetrics([]string{tt.pack}, time.Hour)
+			require.NoError(t, err)
+			derived.Handle(tt.event)
+
+			var actual []string
+			for _, line := range strings.Split(metricsText(t, derived), "\n") {
+				if line != "" && !strings.HasPrefix(line, "#") {
+					actual = append(actual, line)
+				}
+			}
+			require.Equal(t, tt.expected, strings.Join(actual, "\n"))
+		})
+	}
+}
+
+func TestDerivedMetricsCountsNewOccurrences(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	derived, err := NewDerivedMetrics([]string{"probes", "nodes"}, time.Hour)
+	require.NoError(t, err)
+	derived.now = func() time.Time { return now }
+
+	event := podEvent("Unhealthy", "Liveness probe failed: connection refused", "spec.containers{web}")
+	event.Count = 3
+	derived.Handle(event)
+	// The informer resync delivers the same event again.
+	derived.Handle(event)
+	require.Contains(t, metricsText(t, derived), `kube_pod_probe_failures_total{container="web",namespace="default",pod="app",probe="liveness"} 3`)
+
+	event.Count = 5
+	derived.Handle(event)
+	require.Contains(t, metricsText(t, derived), `probe="liveness"} 5`)
+
+	now = now.Add(2 * time.Hour)
+	require.Equal(t, 0, testutil.CollectAndCount(derived))
+	require.Empty(t, derived.seen)
+
+	_, err = NewDerivedMetrics([]string{"unknown"}, time.Hour)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `unknown rule pack "unknown"`)
+}
+
+func TestDerivedMetricsPruneWithoutScrapes(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	derived, err := NewDerivedMetrics([]string{"probes"}, time.Hour)
+	require.NoError(t, err)
+	derived.now = func() time.Time { return now }
+
+	stale := podEvent("Unhealthy", "Liveness probe failed: connection refused", "spec.containers{web}")
+	derived.Handle(stale)
+
+	now = now.Add(2 * time.Hour)
+	fresh := podEvent("Unhealthy", "Readiness probe failed: connection refused", "spec.containers{web}")
+	fresh.UID = "fresh-uid"
+	derived.Handle(fresh)
+
+	require.Len(t, derived.seen, 1)
+	require.Contains(t, derived.seen, fresh.UID)
+	require.Len(t, derived.rules[0].series, 1)
+}
+
+func TestDerivedMetricsTruncateDetailsOnRunes(t *testing.T) {
+	derived, err := NewDerivedMetrics([]string{"scheduling"}, time.Hour)
+	require.NoError(t, err)
+
+	// The 64th byte is in the middle of a two-byte rune.
+	derived.Handle(podEvent("FailedScheduling", "x"+strings.Repeat("а", 40), ""))
+
+	expected := "x" + strings.Repeat("а", 31)
+	require.Contains(t, metricsText(t, derived), `reason_detail="`+expected+`"} 1`)
+}
+
+// metricsText returns derived metrics in the text exposition format.
+func metricsText(t *testing.T, derived *DerivedMetrics) string {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(derived))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	var text strings.Builder
+	for _, family := range families {
+		_, err := expfmt.MetricFamilyToText(&text, family)
+		require.NoError(t, err)
+	}
+	return text.String()
+}
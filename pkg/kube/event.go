@@ -15,78 +15,209 @@ package kube
 
 import (
 	"time"
+	"unicode/utf8"
 
-	"github.com/prometheus/common/log"
+	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
 
+	"github.com/nabokihms/events_exporter/pkg/logging"
+	"github.com/nabokihms/events_exporter/pkg/relabel"
 	"github.com/nabokihms/events_exporter/pkg/vault"
 )
 
-const maxMessageLen = 200
+const (
+	maxMessageLen = 200
 
-func trimMessage(message string) string {
-	if len(message) <= maxMessageLen {
-		return message
+	EventInfoMetric  = "kube_event_info"
+	EventCountMetric = "kube_event_count_total"
+)
+
+var storeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_exporter_store_errors_total",
+	Help: "Event samples failed to be stored to the vault by the reason",
+}, []string{"metric", "reason"})
+
+// MustRegisterMetrics registers metrics of events handling in the registry.
+func MustRegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(storeErrors)
+}
+
+// truncate cuts the string to at most n bytes on a rune boundary, so label values stay valid UTF-8.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
-	return message[:maxMessageLen]
+	return s[:n]
 }
 
 // EventToSample converts Kubernetes core v1.Event to the prometheus metric sample.
 func EventToSample(event *v1.Event, omitEventsMessages bool) vault.Sample {
 	var message string
 	if !omitEventsMessages {
-		message = trimMessage(event.Message)
+		message = truncate(event.Message, maxMessageLen)
+	}
+
+	var exemplar prometheus.Labels
+	if event.UID != "" {
+		exemplar = prometheus.Labels{
+			"event_uid":    string(event.UID),
+			"involved_uid": string(event.InvolvedObject.UID),
+		}
 	}
 
 	return vault.Sample{
 		ID:    string(event.UID),
 		Value: float64(event.Count),
-		Labels: []string{
-			/* type */ event.Type,
-			/* source_component */ event.Source.Component,
-			/* source_host */ event.Source.Host,
-			/* involved_kind */ event.InvolvedObject.Kind,
-			/* involved_name */ event.InvolvedObject.Name,
-			/* involved_namespace */ event.InvolvedObject.Namespace,
-			/* reporting_controller */ event.ReportingController,
-			/* reporting_instance */ event.ReportingInstance,
-			/* reason */ event.Reason,
-			/* message */ message,
+		Labels: prometheus.Labels{
+			"type":                 event.Type,
+			"source_component":     event.Source.Component,
+			"source_host":          event.Source.Host,
+			"involved_kind":        event.InvolvedObject.Kind,
+			"involved_name":        event.InvolvedObject.Name,
+			"involved_namespace":   event.InvolvedObject.Namespace,
+			"reporting_controller": event.ReportingController,
+			"reporting_instance":   event.ReportingInstance,
+			"reason":               event.Reason,
+			"message":              message,
 		},
-		Timestamp: event.LastTimestamp.Local(),
+		Timestamp:      event.LastTimestamp.Local(),
+		FirstTimestamp: event.FirstTimestamp.Local(),
+		Exemplar:       exemplar,
+	}
+}
+
+// EventListener receives every event handled by the callback, e.g., to ship raw events to sinks.
+type EventListener func(event *v1.Event)
+
+// EventConverter turns events into samples of event mappings. Messages are parsed first, so relabeling can use
+// captured labels too. The zero value converts events as EventToSample does.
+type EventConverter struct {
+	OmitMessages   bool
+	Parsers        *MessageParsers
+	RelabelConfigs []*relabel.Config
+}
+
+// LabelNames returns names of labels samples of the converter have, event mappings must have these label names.
+func (c *EventConverter) LabelNames() []string {
+	names := append(eventLabelNames(), c.Parsers.LabelNames()...)
+	return relabel.LabelNames(names, c.RelabelConfigs...)
+}
+
+// Convert returns the sample of the event, or false if the event is dropped by relabeling.
+func (c *EventConverter) Convert(event *v1.Event) (vault.Sample, bool) {
+	sample := EventToSample(event, c.OmitMessages)
+	c.Parsers.ParseLabels(event, sample.Labels)
+
+	labels, keep := relabel.Process(sample.Labels, c.RelabelConfigs...)
+	sample.Labels = labels
+	return sample, keep
+}
+
+// StoreSample stores the sample of an event to the vault. Errors are logged and counted by their reasons,
+// so a single malformed event does not stop handling of others.
+func StoreSample(metricsVault *vault.MetricsVault, metric string, sample vault.Sample) {
+	if err := metricsVault.Store(metric, sample); err != nil {
+		reason := vault.ErrorReason(err)
+		storeErrors.WithLabelValues(metric, reason).Inc()
+		logging.Logger("kube").Error(err, "collecting event", "metric", metric, "reason", reason, "uid", sample.ID)
 	}
 }
 
 // EventCallback generates the handler to connect prometheus metrics vault to the shared event informer.
-func EventCallback(vault *vault.MetricsVault, omitEventsMessages bool) func(obj interface{}) {
-	return func(obj interface{}) {
-		log.With("event", obj).Debug("received event")
+// Every event is converted and stored to all passed mappings, values captured by message parsers are stored
+// to their own mappings, and then the event is passed to listeners.
+func EventCallback(
+	metricsVault *vault.MetricsVault,
+	mappings []vault.Mapping,
+	converter *EventConverter,
+	listeners ...EventListener,
+) func(obj interface{}) {
+	debug := logging.Logger("kube").V(1)
 
+	return func(obj interface{}) {
 		event := obj.(*v1.Event)
-		if err := vault.Store("kube_event_info", EventToSample(event, omitEventsMessages)); err != nil {
-			log.Errorf("collecting event: %v", err)
+		if debug.Enabled() {
+			debug.Info("received event", eventKeysAndValues(event)...)
+		}
+
+		if sample, keep := converter.Convert(event); keep {
+			for _, mapping := range mappings {
+				StoreSample(metricsVault, mapping.Name, sample)
+			}
+		} else if debug.Enabled() {
+			debug.Info("event dropped by relabeling", eventKeysAndValues(event)...)
+		}
+
+		for metric, sample := range converter.Parsers.ParseValues(event) {
+			StoreSample(metricsVault, metric, sample)
 		}
+
+		for _, listener := range listeners {
+			listener(event)
+		}
+	}
+}
+
+// eventKeysAndValues returns fields identifying the event for logs, so the whole object is not logged.
+func eventKeysAndValues(event *v1.Event) []interface{} {
+	return []interface{}{
+		"uid", event.UID,
+		"namespace", event.Namespace,
+		"name", event.Name,
+		"type", event.Type,
+		"reason", event.Reason,
+		"count", event.Count,
+		"involvedKind", event.InvolvedObject.Kind,
+		"involvedNamespace", event.InvolvedObject.Namespace,
+		"involvedName", event.InvolvedObject.Name,
+		"resourceVersion", event.ResourceVersion,
 	}
 }
 
-// EventMapping creates the mapping for the prometheus metrics vault. The order of the labels here should match the one
-// from the sample converter function.
+// EventMapping creates the mapping for the prometheus metrics vault with labels of samples from EventToSample.
 func EventMapping(ttl time.Duration) vault.Mapping {
 	return vault.Mapping{
-		Name: "kube_event_info",
-		Help: "Expose Kubernetes events information",
-		LabelNames: []string{
-			"type",
-			"source_component",
-			"source_host",
-			"involved_kind",
-			"involved_name",
-			"involved_namespace",
-			"reporting_controller",
-			"reporting_instance",
-			"reason",
-			"message",
-		},
-		TTL: ttl,
+		Name:       EventInfoMetric,
+		Help:       "Expose Kubernetes events information",
+		LabelNames: eventLabelNames(),
+		TTL:        ttl,
+	}
+}
+
+// WithEventTimestamps adds the kube_event_first_timestamp_seconds and kube_event_last_timestamp_seconds companion
+// gauges to the mapping.
+func WithEventTimestamps(mapping vault.Mapping) vault.Mapping {
+	mapping.FirstTimestampName = "kube_event_first_timestamp_seconds"
+	mapping.LastTimestampName = "kube_event_last_timestamp_seconds"
+	return mapping
+}
+
+// EventCountMapping creates the counter mapping for events. Samples of this metric are exposed with exemplars
+// linking them to the source event.
+func EventCountMapping(ttl time.Duration) vault.Mapping {
+	return vault.Mapping{
+		Name:       EventCountMetric,
+		Help:       "Kubernetes events count",
+		Type:       vault.CounterType,
+		LabelNames: eventLabelNames(),
+		TTL:        ttl,
+	}
+}
+
+func eventLabelNames() []string {
+	return []string{
+		"type",
+		"source_component",
+		"source_host",
+		"involved_kind",
+		"involved_name",
+		"involved_namespace",
+		"reporting_controller",
+		"reporting_instance",
+		"reason",
+		"message",
 	}
 }
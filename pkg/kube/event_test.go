@@ -16,14 +16,29 @@ package kube
 import (
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/nabokihms/events_exporter/pkg/relabel"
 	"github.com/nabokihms/events_exporter/pkg/vault"
 )
 
+// eventLabels returns labels of the empty event with the message.
+func eventLabels(message string) prometheus.Labels {
+	labels := make(prometheus.Labels)
+	for _, name := range eventLabelNames() {
+		labels[name] = ""
+	}
+	labels["message"] = message
+	return labels
+}
+
 func TestEventToSample(t *testing.T) {
 	tests := []struct {
 		Name         string
@@ -35,9 +50,10 @@ func TestEventToSample(t *testing.T) {
 			Name:       "Empty",
 			InputEvent: v1.Event{},
 			OutputSample: vault.Sample{
-				Value:     0,
-				Labels:    []string{"", "", "", "", "", "", "", "", "", ""},
-				Timestamp: metav1.Time{}.Local(),
+				Value:          0,
+				Labels:         eventLabels(""),
+				Timestamp:      metav1.Time{}.Local(),
+				FirstTimestamp: metav1.Time{}.Local(),
 			},
 		},
 		{
@@ -46,9 +62,23 @@ func TestEventToSample(t *testing.T) {
 				Message: strings.Repeat("toolong", 10000),
 			},
 			OutputSample: vault.Sample{
-				Value:     0,
-				Labels:    []string{"", "", "", "", "", "", "", "", "", strings.Repeat("toolong", 10000)[:200]},
-				Timestamp: metav1.Time{}.Local(),
+				Value:          0,
+				Labels:         eventLabels(strings.Repeat("toolong", 10000)[:200]),
+				Timestamp:      metav1.Time{}.Local(),
+				FirstTimestamp: metav1.Time{}.Local(),
+			},
+		},
+		{
+			// The 200th byte is in the middle of a two-byte rune.
+			Name: "Too long non-ASCII message",
+			InputEvent: v1.Event{
+				Message: "x" + strings.Repeat("ё", 150),
+			},
+			OutputSample: vault.Sample{
+				Value:          0,
+				Labels:         eventLabels("x" + strings.Repeat("ё", 99)),
+				Timestamp:      metav1.Time{}.Local(),
+				FirstTimestamp: metav1.Time{}.Local(),
 			},
 		},
 		{
@@ -57,9 +87,10 @@ func TestEventToSample(t *testing.T) {
 				Count: 5,
 			},
 			OutputSample: vault.Sample{
-				Value:     5,
-				Labels:    []string{"", "", "", "", "", "", "", "", "", ""},
-				Timestamp: metav1.Time{}.Local(),
+				Value:          5,
+				Labels:         eventLabels(""),
+				Timestamp:      metav1.Time{}.Local(),
+				FirstTimestamp: metav1.Time{}.Local(),
 			},
 		},
 		{
@@ -69,9 +100,10 @@ func TestEventToSample(t *testing.T) {
 				Message: "something long",
 			},
 			OutputSample: vault.Sample{
-				Value:     5,
-				Labels:    []string{"", "", "", "", "", "", "", "", "", ""},
-				Timestamp: metav1.Time{}.Local(),
+				Value:          5,
+				Labels:         eventLabels(""),
+				Timestamp:      metav1.Time{}.Local(),
+				FirstTimestamp: metav1.Time{}.Local(),
 			},
 			OmitMessage: true,
 		},
@@ -84,3 +116,68 @@ func TestEventToSample(t *testing.T) {
 		})
 	}
 }
+
+func TestEventToSampleExemplar(t *testing.T) {
+	event := v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{UID: "event-uid"},
+		InvolvedObject: v1.ObjectReference{UID: "pod-uid"},
+	}
+
+	sample := EventToSample(&event, false)
+	require.Equal(t, prometheus.Labels{"event_uid": "event-uid", "involved_uid": "pod-uid"}, sample.Exemplar)
+}
+
+func TestEventCallbackRelabeling(t *testing.T) {
+	var configs []*relabel.Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(`
+- source_labels: [type]
+  regex: Normal
+  action: drop
+- source_labels: [involved_namespace]
+  target_label: namespace
+- regex: message|involved_namespace
+  action: labeldrop`), &configs))
+
+	converter := &EventConverter{RelabelConfigs: configs}
+	mapping := EventMapping(time.Hour)
+	mapping.LabelNames = converter.LabelNames()
+	require.Contains(t, mapping.LabelNames, "namespace")
+	require.NotContains(t, mapping.LabelNames, "message")
+
+	metricsVault := vault.NewVault()
+	require.NoError(t, metricsVault.RegisterMappings([]vault.Mapping{mapping}))
+
+	callback := EventCallback(metricsVault, []vault.Mapping{mapping}, converter)
+	callback(&v1.Event{
+		Type:           "Warning",
+		Reason:         "BackOff",
+		Message:        "Back-off restarting failed container",
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "app", Namespace: "prod"},
+	})
+	callback(&v1.Event{Type: "Normal", Reason: "Pulled"})
+
+	samples := metricsVault.Samples()
+	require.Len(t, samples, 1)
+	require.Equal(t, "prod", samples[0].Labels["namespace"])
+	require.Equal(t, "BackOff", samples[0].Labels["reason"])
+	require.NotContains(t, samples[0].Labels, "message")
+}
+
+func TestEventCallbackStoreErrors(t *testing.T) {
+	mapping := EventMapping(time.Hour)
+	mapping.SeriesLimit = 1
+
+	metricsVault := vault.NewVault()
+	require.NoError(t, metricsVault.RegisterMappings([]vault.Mapping{mapping}))
+
+	// The second mapping is not registered in the vault.
+	unknown := EventCountMapping(time.Hour)
+	callback := EventCallback(metricsVault, []vault.Mapping{mapping, unknown}, &EventConverter{})
+
+	callback(&v1.Event{Reason: "BackOff"})
+	callback(&v1.Event{Reason: "Pulled"})
+
+	require.Equal(t, float64(1), testutil.ToFloat64(storeErrors.WithLabelValues(mapping.Name, "limit_exceeded")))
+	require.Equal(t, float64(2), testutil.ToFloat64(storeErrors.WithLabelValues(unknown.Name, "unknown_mapping")))
+	require.Len(t, metricsVault.Samples(), 1)
+}
@@ -28,12 +28,25 @@ import (
 
 const defaultSyncPeriod = 10 * time.Minute
 
+// EventsSource delivers events from a Kubernetes cluster to the handler.
+type EventsSource interface {
+	Run(stopCh <-chan struct{}, errorCh chan<- error)
+	Status() SourceStatus
+}
+
+var (
+	_ EventsSource = (*EventsInformer)(nil)
+	_ EventsSource = (*EventsWatcher)(nil)
+)
+
 // EventsInformer handles Kubernetes events. The is the shim between metrics storage and Kubernetes cluster.
 type EventsInformer struct {
 	client   kubernetes.Interface
 	informer cache.SharedIndexInformer
 
 	eventHandler func(object interface{})
+
+	statusTracker
 }
 
 // NewEventsInformer creates cached informer to track events from a Kubernetes cluster.
@@ -73,6 +86,7 @@ func (e *EventsInformer) Run(stopCh <-chan struct{}, errorCh chan<- error) {
 		},
 	})
 	err := e.informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		e.setError(err)
 		errorCh <- fmt.Errorf("watch handler: %w", err)
 	})
 	if err != nil {
@@ -83,5 +97,7 @@ func (e *EventsInformer) Run(stopCh <-chan struct{}, errorCh chan<- error) {
 
 	if ok := cache.WaitForCacheSync(stopCh, e.informer.HasSynced); !ok {
 		errorCh <- fmt.Errorf("informer cache is not synced")
+		return
 	}
+	e.setSynced()
 }
@@ -19,13 +19,38 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/prometheus/common/log"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
 )
 
+// NewClient creates the Kubernetes client from the kubeconfig file. If the path is empty, the in-cluster config or
+// the config from the home directory is used.
+func NewClient(kubeconfigPath string) (kubernetes.Interface, error) {
+	return getClient(kubeconfigPath)
+}
+
+// NewDynamicClient creates the dynamic Kubernetes client for custom resources the same way as NewClient.
+func NewDynamicClient(kubeconfigPath string) (dynamic.Interface, error) {
+	cfg, err := getConfig(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(cfg)
+}
+
 func getClient(kubeconfigPath string) (kubernetes.Interface, error) {
+	cfg, err := getConfig(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+func getConfig(kubeconfigPath string) (*rest.Config, error) {
 	var (
 		cfg *rest.Config
 		err error
@@ -35,12 +60,12 @@ func getClient(kubeconfigPath string) (kubernetes.Interface, error) {
 		if err != nil {
 			return nil, fmt.Errorf("new kubernetes client from config %s: %w", kubeconfigPath, err)
 		}
-		log.Infof("using kubeconfig from file: %q", kubeconfigPath)
+		logging.Logger("kube").Info("using kubeconfig from file", "path", kubeconfigPath)
 	} else {
 		cfg, err = rest.InClusterConfig()
 		switch {
 		case err == nil:
-			log.Infof("using in-cluster kubeconfig")
+			logging.Logger("kube").Info("using in-cluster kubeconfig")
 		case !errors.Is(err, rest.ErrNotInCluster):
 			return nil, fmt.Errorf("new kubernetes from cluster: %w", err)
 		default:
@@ -51,9 +76,9 @@ func getClient(kubeconfigPath string) (kubernetes.Interface, error) {
 			if err != nil {
 				return nil, fmt.Errorf("new kubernetes client from homedir %s: %w", userKubeconfigPath, err)
 			}
-			log.Infof("using kubeconfig from homedir: %q", userKubeconfigPath)
+			logging.Logger("kube").Info("using kubeconfig from homedir", "path", userKubeconfigPath)
 		}
 	}
 
-	return kubernetes.NewForConfig(cfg)
+	return cfg, nil
 }
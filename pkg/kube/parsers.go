@@ -0,0 +1,226 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+// MessageParserConfig extracts structured data from messages of events with the reasons using named capture
+// groups of the regular expression.
+type MessageParserConfig struct {
+	Reasons []string `yaml:"reasons"`
+	Regex   string   `yaml:"regex"`
+	// Values maps named groups to names of gauges exposing captured numbers. Other named groups are added
+	// to labels of events.
+	Values map[string]string `yaml:"values,omitempty"`
+}
+
+// valueLabelNames are labels of gauges with captured values in addition to captured labels of the parser.
+var valueLabelNames = []string{"involved_kind", "involved_namespace", "involved_name", "reason"}
+
+// MessageParsers is the registry of message parsers by event reasons.
+type MessageParsers struct {
+	byReason   map[string][]*messageParser
+	labelNames []string
+	mappings   []vault.Mapping
+}
+
+type messageParser struct {
+	re     *regexp.Regexp
+	labels []capturedGroup
+	values []capturedGroup
+}
+
+// capturedGroup is the named group of the parser regex with the label or metric name it is exported as.
+type capturedGroup struct {
+	index int
+	name  string
+}
+
+// NewMessageParsers compiles parsers and checks that they do not override labels of events.
+func NewMessageParsers(configs []MessageParserConfig) (*MessageParsers, error) {
+	p := &MessageParsers{byReason: make(map[string][]*messageParser)}
+
+	reserved := make(map[string]bool)
+	for _, name := range eventLabelNames() {
+		reserved[name] = true
+	}
+	metrics := make(map[string]bool)
+
+	for i, config := range configs {
+		if len(config.Reasons) == 0 {
+			return nil, fmt.Errorf("message parser #%d: at least one reason is required", i)
+		}
+		re, err := regexp.Compile(config.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("message parser #%d: %w", i, err)
+		}
+
+		parser := &messageParser{re: re}
+		groups := make(map[string]bool)
+		for index, name := range re.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			groups[name] = true
+
+			if metric, ok := config.Values[name]; ok {
+				if !model.IsValidMetricName(model.LabelValue(metric)) || metrics[metric] {
+					return nil, fmt.Errorf("message parser #%d: invalid or duplicate metric name %q", i, metric)
+				}
+				metrics[metric] = true
+				parser.values = append(parser.values, capturedGroup{index: index, name: metric})
+				continue
+			}
+
+			if !model.LabelName(name).IsValid() || reserved[name] {
+				return nil, fmt.Errorf("message parser #%d: group %q cannot be a label of events", i, name)
+			}
+			parser.labels = append(parser.labels, capturedGroup{index: index, name: name})
+			p.addLabelName(name)
+		}
+
+		if len(groups) == 0 {
+			return nil, fmt.Errorf("message parser #%d: regex has no named groups", i)
+		}
+		for group := range config.Values {
+			if !groups[group] {
+				return nil, fmt.Errorf("message parser #%d: unknown group %q in values", i, group)
+			}
+		}
+
+		for _, value := range parser.values {
+			labelNames := append([]string(nil), valueLabelNames...)
+			for _, label := range parser.labels {
+				labelNames = append(labelNames, label.name)
+			}
+			p.mappings = append(p.mappings, vault.Mapping{
+				Name:       value.name,
+				Help:       fmt.Sprintf("Value captured from messages of %v events", config.Reasons),
+				LabelNames: labelNames,
+			})
+		}
+
+		for _, reason := range config.Reasons {
+			p.byReason[reason] = append(p.byReason[reason], parser)
+		}
+	}
+	return p, nil
+}
+
+func (p *MessageParsers) addLabelName(name string) {
+	for _, n := range p.labelNames {
+		if n == name {
+			return
+		}
+	}
+	p.labelNames = append(p.labelNames, name)
+}
+
+// LabelNames returns names of labels parsers add to events.
+func (p *MessageParsers) LabelNames() []string {
+	if p == nil {
+		return nil
+	}
+	return p.labelNames
+}
+
+// Mappings returns mappings of gauges with captured values.
+func (p *MessageParsers) Mappings(ttl time.Duration) []vault.Mapping {
+	if p == nil {
+		return nil
+	}
+	mappings := make([]vault.Mapping, 0, len(p.mappings))
+	for _, mapping := range p.mappings {
+		mapping.TTL = ttl
+		mappings = append(mappings, mapping)
+	}
+	return mappings
+}
+
+// ParseLabels adds labels captured from the event message. The first matching parser sets the label,
+// labels of unmatched parsers are left unset, i.e., empty.
+func (p *MessageParsers) ParseLabels(event *v1.Event, labels prometheus.Labels) {
+	if p == nil {
+		return
+	}
+	for _, parser := range p.byReason[event.Reason] {
+		match := parser.re.FindStringSubmatch(event.Message)
+		if match == nil {
+			continue
+		}
+		for _, group := range parser.labels {
+			if _, ok := labels[group.name]; !ok {
+				labels[group.name] = match[group.index]
+			}
+		}
+	}
+}
+
+// ParseValues returns samples of captured values by metric names. Captured groups that are not numbers
+// are skipped.
+func (p *MessageParsers) ParseValues(event *v1.Event) map[string]vault.Sample {
+	if p == nil {
+		return nil
+	}
+
+	var samples map[string]vault.Sample
+	for _, parser := range p.byReason[event.Reason] {
+		if len(parser.values) == 0 {
+			continue
+		}
+		match := parser.re.FindStringSubmatch(event.Message)
+		if match == nil {
+			continue
+		}
+
+		labels := prometheus.Labels{
+			"involved_kind":      event.InvolvedObject.Kind,
+			"involved_namespace": event.InvolvedObject.Namespace,
+			"involved_name":      event.InvolvedObject.Name,
+			"reason":             event.Reason,
+		}
+		for _, group := range parser.labels {
+			labels[group.name] = match[group.index]
+		}
+
+		for _, group := range parser.values {
+			value, err := strconv.ParseFloat(match[group.index], 64)
+			if err != nil {
+				continue
+			}
+			if samples == nil {
+				samples = make(map[string]vault.Sample)
+			}
+			samples[group.name] = vault.Sample{
+				ID:             string(event.UID),
+				Labels:         labels,
+				Value:          value,
+				Timestamp:      event.LastTimestamp.Local(),
+				FirstTimestamp: event.FirstTimestamp.Local(),
+			}
+		}
+	}
+	return samples
+}
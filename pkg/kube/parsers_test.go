@@ -0,0 +1,122 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+const testParsers = `
+- reasons: [FailedScheduling]
+  regex: '(?P<insufficient_count>\d+) Insufficient (?P<insufficient_resource>[\w./-]+)'
+  values:
+    insufficient_count: kube_event_insufficient_nodes
+- reasons: [Failed, ErrImagePull]
+  regex: '^Failed to pull image "(?P<image>[^"]+)"'
+`
+
+func newTestParsers(t *testing.T, content string) *MessageParsers {
+	t.Helper()
+	var configs []MessageParserConfig
+	require.NoError(t, yaml.UnmarshalStrict([]byte(content), &configs))
+	parsers, err := NewMessageParsers(configs)
+	require.NoError(t, err)
+	return parsers
+}
+
+func TestMessageParsers(t *testing.T) {
+	converter := &EventConverter{Parsers: newTestParsers(t, testParsers), OmitMessages: true}
+	require.Equal(t, []string{"insufficient_resource", "image"}, converter.Parsers.LabelNames())
+
+	mapping := EventMapping(time.Hour)
+	mapping.LabelNames = converter.LabelNames()
+	mappings := append([]vault.Mapping{mapping}, converter.Parsers.Mappings(time.Hour)...)
+
+	metricsVault := vault.NewVault()
+	require.NoError(t, metricsVault.RegisterMappings(mappings))
+	callback := EventCallback(metricsVault, []vault.Mapping{mapping}, converter)
+
+	pod := v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "app"}
+	callback(&v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{UID: "scheduling"},
+		Reason:         "FailedScheduling",
+		Message:        "0/12 nodes are available: 3 Insufficient cpu, 9 node(s) had taint {node-role: }.",
+		InvolvedObject: pod,
+	})
+	callback(&v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{UID: "pull"},
+		Reason:         "Failed",
+		Message:        `Failed to pull image "nginx:latst": rpc error: code = NotFound`,
+		InvolvedObject: pod,
+	})
+	// Unmatched messages are stored without captured labels.
+	callback(&v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{UID: "unmatched"},
+		Reason:         "FailedScheduling",
+		Message:        `persistentvolumeclaim "data" not found`,
+		InvolvedObject: pod,
+	})
+
+	samples := make(map[string]vault.StoredSample)
+	for _, s := range metricsVault.Samples() {
+		samples[s.Metric+"/"+s.ID] = s
+	}
+	require.Len(t, samples, 4)
+
+	require.Equal(t, "cpu", samples["kube_event_info/scheduling"].Labels["insufficient_resource"])
+	require.Equal(t, "", samples["kube_event_info/scheduling"].Labels["image"])
+	require.Equal(t, "nginx:latst", samples["kube_event_info/pull"].Labels["image"])
+	require.Equal(t, "", samples["kube_event_info/unmatched"].Labels["insufficient_resource"])
+
+	value := samples["kube_event_insufficient_nodes/scheduling"]
+	require.Equal(t, float64(3), value.Value)
+	require.Equal(t, map[string]string{
+		"involved_kind":         "Pod",
+		"involved_namespace":    "default",
+		"involved_name":         "app",
+		"reason":                "FailedScheduling",
+		"insufficient_resource": "cpu",
+	}, value.Labels)
+}
+
+func TestInvalidMessageParsers(t *testing.T) {
+	tests := []struct {
+		name   string
+		config MessageParserConfig
+		error  string
+	}{
+		{name: "no reasons", config: MessageParserConfig{Regex: `(?P<a>.+)`}, error: "at least one reason is required"},
+		{name: "invalid regex", config: MessageParserConfig{Reasons: []string{"BackOff"}, Regex: `(?P<a>`}, error: "missing closing )"},
+		{name: "no named groups", config: MessageParserConfig{Reasons: []string{"BackOff"}, Regex: `(.+)`}, error: "regex has no named groups"},
+		{name: "event label", config: MessageParserConfig{Reasons: []string{"BackOff"}, Regex: `(?P<reason>.+)`}, error: `group "reason" cannot be a label of events`},
+		{name: "unknown value group", config: MessageParserConfig{Reasons: []string{"BackOff"}, Regex: `(?P<a>.+)`, Values: map[string]string{"b": "metric"}}, error: `unknown group "b"`},
+		{name: "invalid metric", config: MessageParserConfig{Reasons: []string{"BackOff"}, Regex: `(?P<a>.+)`, Values: map[string]string{"a": "metric-name"}}, error: `invalid or duplicate metric name "metric-name"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMessageParsers([]MessageParserConfig{tt.config})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.error)
+		})
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"regexp"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// DerivedRule counts events with well-known reasons as a dedicated counter.
+type DerivedRule struct {
+	Metric     string
+	Help       string
+	Reasons    []string
+	LabelNames []string
+	// Labels extracts label values of counted series from the event. The event is skipped if there are none.
+	Labels func(event *v1.Event) [][]string
+}
+
+// RulePack is a set of derived rules enabled together.
+type RulePack struct {
+	Name  string
+	Rules []DerivedRule
+}
+
+var (
+	containerFieldPathRe    = regexp.MustCompile(`^spec\.(?:initContainers|containers|ephemeralContainers)\{(.+)\}$`)
+	schedulingDetailsRe     = regexp.MustCompile(`nodes are available: (.+?)\.(?:\s|$)`)
+	schedulingDetailCountRe = regexp.MustCompile(`^\d+ `)
+	bracesRe                = regexp.MustCompile(`\s*\{[^}]*\}`)
+	quotedRe                = regexp.MustCompile(`\s*"[^"]*"`)
+	killedProcessRe         = regexp.MustCompile(`Killed process \d+ \(([^)]+)\)`)
+	lowOnResourceRe         = regexp.MustCompile(`low on resource: ([\w./-]+?)\.?(?:\s|$)`)
+	pullImageRe             = regexp.MustCompile(`^Failed to pull image "([^"]+)"`)
+	probeRe                 = regexp.MustCompile(`^(Liveness|Readiness|Startup) probe`)
+	restartContainerRe      = regexp.MustCompile(`^Back-off restarting failed container(?: (\S+) in pod)?`)
+	mountVolumeRe           = regexp.MustCompile(`MountVolume\.\w+ failed for volume "([^"]+)"`)
+	unmountedVolumesRe      = regexp.MustCompile(`unmounted volumes=\[([^\]]*)\]`)
+)
+
+// maxDetailLen limits the length of details taken from messages as is, to keep cardinality reasonable.
+const maxDetailLen = 64
+
+// BuiltinRulePacks are derived metrics for event reasons of Kubernetes components with known meanings.
+var BuiltinRulePacks = []RulePack{
+	{
+		Name: "scheduling",
+		Rules: []DerivedRule{{
+			Metric:     "kube_pod_scheduling_failures_total",
+			Help:       "Failed attempts to schedule pods by the cause reported by the scheduler",
+			Reasons:    []string{"FailedScheduling"},
+			LabelNames: []string{"namespace", "reason_detail"},
+			Labels: func(event *v1.Event) [][]string {
+				var labels [][]string
+				for _, detail := range schedulingDetails(event.Message) {
+					labels = append(labels, []string{event.InvolvedObject.Namespace, detail})
+				}
+				return labels
+			},
+		}},
+	},
+	{
+		Name: "oom",
+		Rules: []DerivedRule{{
+			Metric:     "kube_pod_oom_events_total",
+			Help:       "Processes killed by the OOM killer, namespace and pod are empty for events of nodes",
+			Reasons:    []string{"OOMKilling"},
+			LabelNames: []string{"namespace", "pod", "container"},
+			Labels: func(event *v1.Event) [][]string {
+				if event.InvolvedObject.Kind == "Pod" {
+					return [][]string{{event.InvolvedObject.Namespace, event.InvolvedObject.Name, containerName(event)}}
+				}
+				// Node problem detector reports OOM kills of nodes with the killed process name.
+				var process string
+				if match := killedProcessRe.FindStringSubmatch(event.Message); match != nil {
+					process = match[1]
+				}
+				return [][]string{{"", "", process}}
+			},
+		}},
+	},
+	{
+		Name: "eviction",
+		Rules: []DerivedRule{{
+			Metric:     "kube_pod_evictions_total",
+			Help:       "Pods evicted by kubelets by the starved resource",
+			Reasons:    []string{"Evicted"},
+			LabelNames: []string{"namespace", "resource"},
+			Labels: func(event *v1.Event) [][]string {
+				resource := "other"
+				if match := lowOnResourceRe.FindStringSubmatch(event.Message); match != nil {
+					resource = match[1]
+				} else if strings.Contains(event.Message, "ephemeral local storage") {
+					resource = "ephemeral-storage"
+				}
+				return [][]string{{event.InvolvedObject.Namespace, resource}}
+			},
+		}},
+	},
+	{
+		Name: "image",
+		Rules: []DerivedRule{{
+			Metric:     "kube_image_pull_failures_total",
+			Help:       "Failed image pulls by the image",
+			Reasons:    []string{"Failed"},
+			LabelNames: []string{"image"},
+			Labels: func(event *v1.Event) [][]string {
+				if match := pullImageRe.FindStringSubmatch(event.Message); match != nil {
+					return [][]string{{match[1]}}
+				}
+				return nil
+			},
+		}},
+	},
+	{
+		Name: "probes",
+		Rules: []DerivedRule{{
+			Metric:     "kube_pod_probe_failures_total",
+			Help:       "Failed container probes by the probe type",
+			Reasons:    []string{"Unhealthy"},
+			LabelNames: []string{"namespace", "pod", "container", "probe"},
+			Labels: func(event *v1.Event) [][]string {
+				match := probeRe.FindStringSubmatch(event.Message)
+				if match == nil {
+					return nil
+				}
+				return [][]string{{event.InvolvedObject.Namespace, event.InvolvedObject.Name, containerName(event), strings.ToLower(match[1])}}
+			},
+		}},
+	},
+	{
+		Name: "restarts",
+		Rules: []DerivedRule{{
+			Metric:     "kube_pod_container_restart_backoffs_total",
+			Help:       "Back-offs of restarting failed containers, i.e., crash loops",
+			Reasons:    []string{"BackOff"},
+			LabelNames: []string{"namespace", "pod", "container"},
+			Labels: func(event *v1.Event) [][]string {
+				match := restartContainerRe.FindStringSubmatch(event.Message)
+				if match == nil {
+					return nil
+				}
+				container := containerName(event)
+				if container == "" {
+					container = match[1]
+				}
+				return [][]string{{event.InvolvedObject.Namespace, event.InvolvedObject.Name, container}}
+			},
+		}},
+	},
+	{
+		Name: "volumes",
+		Rules: []DerivedRule{{
+			Metric:     "kube_pod_volume_mount_failures_total",
+			Help:       "Failed volume mounts by the volume, the volume is empty if the message does not name it",
+			Reasons:    []string{"FailedMount"},
+			LabelNames: []string{"namespace", "volume"},
+			Labels: func(event *v1.Event) [][]string {
+				namespace := event.InvolvedObject.Namespace
+				if match := mountVolumeRe.FindStringSubmatch(event.Message); match != nil {
+					return [][]string{{namespace, match[1]}}
+				}
+				var labels [][]string
+				if match := unmountedVolumesRe.FindStringSubmatch(event.Message); match != nil {
+					for _, volume := range strings.Fields(match[1]) {
+						labels = append(labels, []string{namespace, volume})
+					}
+				}
+				if len(labels) == 0 {
+					labels = [][]string{{namespace, ""}}
+				}
+				return labels
+			},
+		}},
+	},
+	{
+		Name: "nodes",
+		Rules: []DerivedRule{{
+			Metric:     "kube_node_not_ready_events_total",
+			Help:       "Transitions of nodes to the NotReady state",
+			Reasons:    []string{"NodeNotReady"},
+			LabelNames: []string{"node"},
+			Labels: func(event *v1.Event) [][]string {
+				// The node controller also reports pods of not ready nodes with the same reason.
+				if event.InvolvedObject.Kind != "Node" {
+					return nil
+				}
+				return [][]string{{event.InvolvedObject.Name}}
+			},
+		}},
+	},
+	{
+		Name: "sandbox",
+		Rules: []DerivedRule{{
+			Metric:     "kube_pod_sandbox_failures_total",
+			Help:       "Failed creations of pod sandboxes by the cause: network, timeout or runtime",
+			Reasons:    []string{"FailedCreatePodSandBox"},
+			LabelNames: []string{"namespace", "cause"},
+			Labels: func(event *v1.Event) [][]string {
+				message := strings.ToLower(event.Message)
+				cause := "runtime"
+				switch {
+				case strings.Contains(message, "network") || strings.Contains(message, "cni"):
+					cause = "network"
+				case strings.Contains(message, "deadline exceeded") || strings.Contains(message, "timeout"):
+					cause = "timeout"
+				}
+				return [][]string{{event.InvolvedObject.Namespace, cause}}
+			},
+		}},
+	},
+}
+
+// containerName returns the container name from the field path of the involved object, e.g., spec.containers{app}.
+func containerName(event *v1.Event) string {
+	if match := containerFieldPathRe.FindStringSubmatch(event.InvolvedObject.FieldPath); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// schedulingDetails returns causes from the scheduler message without node counts and taint values, e.g.,
+// "Insufficient cpu" and "node(s) had untolerated taint" for
+// "0/3 nodes are available: 1 Insufficient cpu, 2 node(s) had untolerated taint {key: value}.".
+func schedulingDetails(message string) []string {
+	match := schedulingDetailsRe.FindStringSubmatch(message)
+	if match == nil {
+		// Other messages name objects, e.g., unbound claims, names are removed to keep cardinality reasonable.
+		detail := strings.TrimRight(strings.TrimSpace(quotedRe.ReplaceAllString(message, "")), ".")
+		return []string{truncate(detail, maxDetailLen)}
+	}
+
+	var details []string
+	for _, detail := range strings.Split(bracesRe.ReplaceAllString(match[1], ""), ", ") {
+		details = append(details, schedulingDetailCountRe.ReplaceAllString(strings.TrimSpace(detail), ""))
+	}
+	return details
+}
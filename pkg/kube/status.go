@@ -0,0 +1,64 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"sync"
+	"time"
+)
+
+// SourceStatus is the state of the events source, e.g., to show it to users.
+type SourceStatus struct {
+	// Synced is true once all existing events were listed.
+	Synced   bool      `json:"synced"`
+	SyncedAt time.Time `json:"synced_at,omitempty"`
+
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitempty"`
+}
+
+// statusTracker is embedded into events sources to record their status.
+type statusTracker struct {
+	mu     sync.RWMutex
+	status SourceStatus
+	now    func() time.Time
+}
+
+// Status returns the current status of the source.
+func (s *statusTracker) Status() SourceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+func (s *statusTracker) setSynced() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Synced = true
+	s.status.SyncedAt = s.currentTime()
+}
+
+func (s *statusTracker) setError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.LastError = err.Error()
+	s.status.LastErrorAt = s.currentTime()
+}
+
+func (s *statusTracker) currentTime() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
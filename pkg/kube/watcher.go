@@ -0,0 +1,243 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
+)
+
+const (
+	listPageSize        = 500
+	watchTimeoutSeconds = int64(5 * time.Minute / time.Second)
+)
+
+// defaultBackoff delays retries of failed lists and watches, e.g., while the API server is restarted.
+var defaultBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    math.MaxInt32,
+	Cap:      30 * time.Second,
+}
+
+// errUnexpectedObject is returned for objects other than events in the watch stream.
+var errUnexpectedObject = errors.New("unexpected object in the watch stream")
+
+// EventsWatcher streams events from a Kubernetes cluster without keeping them in a local cache.
+// It only remembers the last seen resource version to be able to resume the watch.
+type EventsWatcher struct {
+	client        kubernetes.Interface
+	fieldSelector string
+
+	resourceVersion string
+
+	eventHandler func(object interface{})
+	backoff      wait.Backoff
+
+	statusTracker
+}
+
+// NewEventsWatcher creates cacheless watcher to track events from a Kubernetes cluster.
+func NewEventsWatcher(kubeconfigPath, fieldSelector string, handler func(object interface{})) (*EventsWatcher, error) {
+	client, err := getClient(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return newWatcher(client, fieldSelector, handler), nil
+}
+
+func newWatcher(client kubernetes.Interface, fieldSelector string, handler func(object interface{})) *EventsWatcher {
+	return &EventsWatcher{client: client, fieldSelector: fieldSelector, eventHandler: handler, backoff: defaultBackoff}
+}
+
+// Run lists all events, passes them to the handler and then keeps watching for changes in the background.
+// Failed lists and watches are retried with backoff, only errors that retries cannot fix are sent to the error channel.
+func (e *EventsWatcher) Run(stopCh <-chan struct{}, errorCh chan<- error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	if err := e.listWithRetries(ctx); err != nil {
+		if ctx.Err() == nil {
+			errorCh <- fmt.Errorf("list events: %w", err)
+		}
+		return
+	}
+	e.setSynced()
+
+	go func() {
+		backoff := e.backoff
+		for {
+			err := e.watch(ctx)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err == nil:
+				// The server closed the stream after the timeout.
+				backoff = e.backoff
+			case apierrors.IsResourceExpired(err) || apierrors.IsGone(err):
+				// The resource version we remember is too old, the only way to continue is to start over.
+				logging.Logger("kube").Info("events resource version expired, relisting", "resourceVersion", e.resourceVersion)
+				e.setError(err)
+				if err := e.listWithRetries(ctx); err != nil {
+					if ctx.Err() == nil {
+						errorCh <- fmt.Errorf("relist events: %w", err)
+					}
+					return
+				}
+			case IsFatalError(err):
+				e.setError(err)
+				errorCh <- fmt.Errorf("watch handler: %w", err)
+				return
+			default:
+				e.setError(err)
+				delay := backoff.Step()
+				logging.Logger("kube").Info("watch events failed, retrying", "backoff", delay, "error", err)
+				if !sleep(ctx, delay) {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// listWithRetries lists events until it succeeds, fails with a fatal error or the context is done.
+func (e *EventsWatcher) listWithRetries(ctx context.Context) error {
+	backoff := e.backoff
+	for {
+		err := e.list(ctx)
+		if err == nil {
+			return nil
+		}
+		e.setError(err)
+		if IsFatalError(err) {
+			return err
+		}
+
+		delay := backoff.Step()
+		logging.Logger("kube").Info("list events failed, retrying", "backoff", delay, "error", err)
+		if !sleep(ctx, delay) {
+			return ctx.Err()
+		}
+	}
+}
+
+// IsFatalError reports errors that retries cannot fix, e.g., missing permissions or an invalid field selector.
+func IsFatalError(err error) bool {
+	return apierrors.IsForbidden(err) ||
+		apierrors.IsUnauthorized(err) ||
+		apierrors.IsBadRequest(err) ||
+		apierrors.IsInvalid(err) ||
+		apierrors.IsNotFound(err) ||
+		apierrors.IsMethodNotSupported(err) ||
+		errors.Is(err, errUnexpectedObject)
+}
+
+// sleep waits for the delay and returns false if the context is done earlier.
+func sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// list passes every event to the handler page by page, so the whole list is never held in memory at once.
+func (e *EventsWatcher) list(ctx context.Context) error {
+	opts := metav1.ListOptions{FieldSelector: e.fieldSelector, Limit: listPageSize}
+	for {
+		events, err := e.client.CoreV1().Events(metav1.NamespaceAll).List(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		for i := range events.Items {
+			e.eventHandler(&events.Items[i])
+		}
+
+		e.resourceVersion = events.ResourceVersion
+		if events.Continue == "" {
+			return nil
+		}
+		opts.Continue = events.Continue
+	}
+}
+
+// watch follows changes from the last seen resource version until the server closes the stream.
+func (e *EventsWatcher) watch(ctx context.Context) error {
+	timeout := watchTimeoutSeconds
+	w, err := e.client.CoreV1().Events(metav1.NamespaceAll).Watch(ctx, metav1.ListOptions{
+		FieldSelector:       e.fieldSelector,
+		ResourceVersion:     e.resourceVersion,
+		AllowWatchBookmarks: true,
+		TimeoutSeconds:      &timeout,
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				obj, ok := event.Object.(*v1.Event)
+				if !ok {
+					return fmt.Errorf("%w: %T", errUnexpectedObject, event.Object)
+				}
+				e.eventHandler(obj)
+				e.resourceVersion = obj.ResourceVersion
+			case watch.Bookmark:
+				obj, ok := event.Object.(*v1.Event)
+				if !ok {
+					return fmt.Errorf("%w: bookmark %T", errUnexpectedObject, event.Object)
+				}
+				e.resourceVersion = obj.ResourceVersion
+			case watch.Deleted:
+				// Deleted events are removed from the vault by TTL, same as for the informer.
+				if obj, ok := event.Object.(*v1.Event); ok {
+					e.resourceVersion = obj.ResourceVersion
+				}
+			case watch.Error:
+				return apierrors.FromObject(event.Object)
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type receivedEvents struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (r *receivedEvents) handle(obj interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names = append(r.names, obj.(*v1.Event).Name)
+}
+
+func (r *receivedEvents) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.names...)
+}
+
+func testEvent(name, resourceVersion string) *v1.Event {
+	return &v1.Event{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", ResourceVersion: resourceVersion}}
+}
+
+func TestEventsWatcher(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		list := &v1.EventList{ListMeta: metav1.ListMeta{ResourceVersion: "3"}, Items: []v1.Event{*testEvent("listed", "3")}}
+		return true, list, nil
+	})
+
+	var (
+		watchMu       sync.Mutex
+		watchVersions []string
+	)
+	watchers := make(chan *watch.FakeWatcher, 3)
+	client.PrependWatchReactor("events", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watchMu.Lock()
+		watchVersions = append(watchVersions, action.(k8stesting.WatchActionImpl).WatchRestrictions.ResourceVersion)
+		watchMu.Unlock()
+
+		w := watch.NewFake()
+		watchers <- w
+		return true, w, nil
+	})
+
+	received := &receivedEvents{}
+	watcher := newWatcher(client, "", received.handle)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	errorCh := make(chan error, 1)
+
+	require.False(t, watcher.Status().Synced)
+	watcher.Run(stopCh, errorCh)
+	require.Equal(t, []string{"listed"}, received.get())
+	require.True(t, watcher.Status().Synced)
+
+	// The watch is resumed from the last bookmark after the server closes the stream.
+	w := <-watchers
+	w.Add(testEvent("added", "5"))
+	w.Action(watch.Bookmark, testEvent("", "10"))
+	w.Stop()
+
+	// After the expired resource version the watcher must relist and watch again.
+	w = <-watchers
+	w.Modify(testEvent("added", "11"))
+	w.Error(&metav1.Status{Status: metav1.StatusFailure, Code: http.StatusGone, Reason: metav1.StatusReasonExpired})
+
+	<-watchers
+	require.Equal(t, []string{"listed", "added", "added", "listed"}, received.get())
+
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	require.Equal(t, []string{"3", "10", "3"}, watchVersions)
+	require.Len(t, errorCh, 0)
+}
+
+func TestEventsWatcherError(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	watchers := make(chan *watch.FakeWatcher, 1)
+	client.PrependWatchReactor("events", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		watchers <- w
+		return true, w, nil
+	})
+
+	watcher := newWatcher(client, "", func(object interface{}) {})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	errorCh := make(chan error, 1)
+
+	watcher.Run(stopCh, errorCh)
+
+	w := <-watchers
+	w.Action(watch.Added, runtime.Object(&v1.Pod{}))
+
+	select {
+	case err := <-errorCh:
+		require.Contains(t, err.Error(), "unexpected object")
+		require.Contains(t, watcher.Status().LastError, "unexpected object")
+	case <-time.After(time.Second):
+		t.Fatal("error was not reported")
+	}
+}
+
+func TestEventsWatcherRetries(t *testing.T) {
+	client := fake.NewSimpleClientset(testEvent("listed", "3"))
+
+	lists := 0
+	client.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+		if lists == 1 {
+			return true, nil, apierrors.NewInternalError(errors.New("etcd is unavailable"))
+		}
+		return false, nil, nil
+	})
+
+	watchers := make(chan *watch.FakeWatcher, 1)
+	watches := 0
+	client.PrependWatchReactor("events", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watches++
+		switch watches {
+		case 1:
+			return true, nil, apierrors.NewServiceUnavailable("the server is restarting")
+		case 2:
+			w := watch.NewFake()
+			watchers <- w
+			return true, w, nil
+		default:
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "events"}, "", errors.New("no permissions"))
+		}
+	})
+
+	received := &receivedEvents{}
+	watcher := newWatcher(client, "", received.handle)
+	watcher.backoff = wait.Backoff{Duration: time.Millisecond, Steps: 1}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	errorCh := make(chan error, 1)
+
+	// Server errors are retried.
+	watcher.Run(stopCh, errorCh)
+	require.True(t, watcher.Status().Synced)
+	require.Equal(t, []string{"listed"}, received.get())
+	require.Contains(t, watcher.Status().LastError, "etcd is unavailable")
+
+	w := <-watchers
+	require.Contains(t, watcher.Status().LastError, "the server is restarting")
+	require.Len(t, errorCh, 0)
+
+	// Missing permissions cannot be fixed by retries.
+	w.Stop()
+	select {
+	case err := <-errorCh:
+		require.True(t, apierrors.IsForbidden(err))
+	case <-time.After(time.Second):
+		t.Fatal("error was not reported")
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging sets up the structured logger of the exporter. Components log through logr, and the zap backend
+// writes text or JSON lines. Logs of Kubernetes clients are written by the same logger.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/klog/v2"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Config of the logger.
+type Config struct {
+	// Level is the min level of logs: debug, info, warn or error.
+	Level string
+	// Verbosity is the max verbosity of logs written with logr.Logger.V. Debug logs have verbosity 1, Kubernetes
+	// clients log up to verbosity 10. The debug level is the same as verbosity 1.
+	Verbosity int
+	// Format is either json or text.
+	Format string
+}
+
+var (
+	mu   sync.RWMutex
+	root = logr.Discard()
+)
+
+// Logger returns the logger of the named component, e.g., vault, or the root logger if the name is empty.
+// Logs are discarded until Setup is called, e.g., in tests.
+func Logger(name string) logr.Logger {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if name == "" {
+		return root
+	}
+	return root.WithName(name)
+}
+
+// Setup replaces the logger of the exporter and Kubernetes clients with the one writing to stdout.
+func Setup(config Config) error {
+	logger, err := New(config, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	// klog checks its own verbosity before passing logs to the logger.
+	var flags flag.FlagSet
+	klog.InitFlags(&flags)
+	if err := flags.Set("v", strconv.Itoa(verbosity(config))); err != nil {
+		return fmt.Errorf("set klog verbosity: %w", err)
+	}
+	klog.SetLogger(logger.WithName("klog"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	root = logger
+	return nil
+}
+
+// New creates the logger writing to the writer.
+func New(config Config, w io.Writer) (logr.Logger, error) {
+	level, err := zapcore.ParseLevel(config.Level)
+	if err != nil {
+		return logr.Logger{}, fmt.Errorf("log level: %w", err)
+	}
+	if config.Verbosity < 0 {
+		return logr.Logger{}, fmt.Errorf("negative log verbosity %d", config.Verbosity)
+	}
+	// logr verbosity is the negative zap level, i.e., V(1) is the debug level.
+	if v := zapcore.Level(-config.Verbosity); config.Verbosity > 0 && v < level {
+		level = v
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "time"
+	encoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+
+	var encoder zapcore.Encoder
+	switch config.Format {
+	case FormatJSON:
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case FormatText:
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return logr.Logger{}, fmt.Errorf("unknown log format %q", config.Format)
+	}
+
+	core := zapcore.NewCore(encoder, zapcore.AddSync(w), zap.NewAtomicLevelAt(level))
+	return zapr.NewLoggerWithOptions(zap.New(core, zap.AddCaller()), zapr.LogInfoLevel("v")), nil
+}
+
+func verbosity(config Config) int {
+	if config.Level == "debug" && config.Verbosity < 1 {
+		return 1
+	}
+	return config.Verbosity
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestLogger(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		messages []string
+	}{
+		{name: "info", config: Config{Level: "info", Format: FormatJSON}, messages: []string{"info", "error"}},
+		{name: "debug", config: Config{Level: "debug", Format: FormatJSON}, messages: []string{"info", "debug", "error"}},
+		{name: "verbosity", config: Config{Level: "info", Verbosity: 4, Format: FormatJSON}, messages: []string{"info", "debug", "trace", "error"}},
+		{name: "error", config: Config{Level: "error", Format: FormatJSON}, messages: []string{"error"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := New(tt.config, &buf)
+			require.NoError(t, err)
+
+			logger.Info("info")
+			logger.V(1).Info("debug")
+			logger.V(4).Info("trace")
+			logger.Error(errors.New("failed"), "error")
+
+			var messages []string
+			for _, entry := range decodeLines(t, &buf) {
+				messages = append(messages, entry["msg"].(string))
+			}
+			require.Equal(t, tt.messages, messages)
+		})
+	}
+}
+
+func TestLoggerKeysAndValues(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(Config{Level: "debug", Format: FormatJSON}, &buf)
+	require.NoError(t, err)
+
+	logger.WithName("vault").WithName("bolt").WithValues("metric", "kube_event_info").
+		V(1).Info("stored sample", "id", "uid-1", "value", 2)
+	logger.Error(errors.New("connection refused"), "remote write", "remote", "http://localhost")
+
+	lines := decodeLines(t, &buf)
+	require.Len(t, lines, 2)
+
+	require.Equal(t, "vault.bolt", lines[0]["logger"])
+	require.Equal(t, "stored sample", lines[0]["msg"])
+	require.Equal(t, "kube_event_info", lines[0]["metric"])
+	require.Equal(t, "uid-1", lines[0]["id"])
+	require.Equal(t, float64(2), lines[0]["value"])
+	require.Equal(t, float64(1), lines[0]["v"])
+	require.Equal(t, "debug", lines[0]["level"])
+
+	require.Equal(t, "error", lines[1]["level"])
+	require.Equal(t, "connection refused", lines[1]["error"])
+	require.Equal(t, "http://localhost", lines[1]["remote"])
+}
+
+func TestTextFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(Config{Level: "info", Format: FormatText}, &buf)
+	require.NoError(t, err)
+
+	logger.WithName("server").Info("start exporting metrics", "address", ":9000")
+	require.Contains(t, buf.String(), "info\tserver\t")
+	require.Contains(t, buf.String(), `start exporting metrics	{"v": 0, "address": ":9000"}`)
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		error  string
+	}{
+		{name: "level", config: Config{Level: "verbose", Format: FormatJSON}, error: "log level"},
+		{name: "verbosity", config: Config{Level: "info", Verbosity: -1, Format: FormatJSON}, error: "negative log verbosity"},
+		{name: "format", config: Config{Level: "info", Format: "logfmt"}, error: `unknown log format "logfmt"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.config, &bytes.Buffer{})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.error)
+		})
+	}
+}
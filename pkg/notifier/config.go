@@ -0,0 +1,185 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const (
+	JSONFormat         = "json"
+	AlertmanagerFormat = "alertmanager"
+)
+
+// Config is the notifier section of the configuration file.
+type Config struct {
+	// MaxEventAge skips events that happened earlier, e.g., to not notify about all events listed on start.
+	MaxEventAge time.Duration `yaml:"max_event_age,omitempty"`
+
+	Receivers []ReceiverConfig `yaml:"receivers,omitempty"`
+	Rules     []RuleConfig     `yaml:"rules,omitempty"`
+}
+
+// DefaultMaxEventAge is used if the max event age is not set.
+const DefaultMaxEventAge = 5 * time.Minute
+
+// ReceiverConfig describes an HTTP endpoint notifications are posted to.
+type ReceiverConfig struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Format is either json (default) or alertmanager for the Alertmanager v2 API payload.
+	Format      string        `yaml:"format,omitempty"`
+	BearerToken string        `yaml:"bearer_token,omitempty"`
+	Timeout     time.Duration `yaml:"timeout,omitempty"`
+
+	// BatchSize is the max number of notifications in a request.
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// BatchInterval is the max time a notification waits for the batch.
+	BatchInterval time.Duration `yaml:"batch_interval,omitempty"`
+	// QueueCapacity is the max number of notifications waiting to be sent. New notifications go to the dead letters.
+	QueueCapacity int `yaml:"queue_capacity,omitempty"`
+
+	// RateLimit is the max number of requests per second.
+	RateLimit  float64       `yaml:"rate_limit,omitempty"`
+	MaxRetries int           `yaml:"max_retries,omitempty"`
+	MinBackoff time.Duration `yaml:"min_backoff,omitempty"`
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+
+	// AlertResolveTimeout is the time after which Alertmanager resolves an alert created from an event.
+	AlertResolveTimeout time.Duration `yaml:"alert_resolve_timeout,omitempty"`
+}
+
+// DefaultReceiverConfig is applied to every receiver section before unmarshalling.
+var DefaultReceiverConfig = ReceiverConfig{
+	Format:              JSONFormat,
+	Timeout:             10 * time.Second,
+	BatchSize:           50,
+	BatchInterval:       5 * time.Second,
+	QueueCapacity:       1000,
+	RateLimit:           1,
+	MaxRetries:          3,
+	MinBackoff:          time.Second,
+	MaxBackoff:          30 * time.Second,
+	AlertResolveTimeout: 5 * time.Minute,
+}
+
+// UnmarshalYAML sets defaults for omitted fields.
+func (c *ReceiverConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultReceiverConfig
+	type plain ReceiverConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate checks that the receiver config is complete.
+func (c *ReceiverConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("receiver name is required")
+	}
+	if _, err := url.ParseRequestURI(c.URL); err != nil {
+		return fmt.Errorf("receiver %q url: %w", c.Name, err)
+	}
+	if c.Format != JSONFormat && c.Format != AlertmanagerFormat {
+		return fmt.Errorf("receiver %q: unknown format %q", c.Name, c.Format)
+	}
+	if c.BatchSize <= 0 || c.BatchInterval <= 0 || c.QueueCapacity <= 0 {
+		return fmt.Errorf("receiver %q: batch size, batch interval and queue capacity must be positive", c.Name)
+	}
+	if c.RateLimit <= 0 {
+		return fmt.Errorf("receiver %q: rate limit must be positive", c.Name)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("receiver %q: timeout must be positive", c.Name)
+	}
+	if c.MaxRetries < 0 {
+		return fmt.Errorf("receiver %q: max retries must not be negative", c.Name)
+	}
+	if c.MinBackoff <= 0 || c.MaxBackoff < c.MinBackoff {
+		return fmt.Errorf("receiver %q: min backoff must be positive and not greater than max backoff", c.Name)
+	}
+	return nil
+}
+
+// RuleConfig selects events to notify about. Every field is a list of anchored regular expressions,
+// an event matches the rule if it matches any expression of every non-empty list.
+type RuleConfig struct {
+	Name     string `yaml:"name"`
+	Receiver string `yaml:"receiver"`
+
+	Namespaces    []string `yaml:"namespaces,omitempty"`
+	Types         []string `yaml:"types,omitempty"`
+	Reasons       []string `yaml:"reasons,omitempty"`
+	InvolvedKinds []string `yaml:"involved_kinds,omitempty"`
+}
+
+type rule struct {
+	name     string
+	receiver string
+
+	namespaces    []*regexp.Regexp
+	types         []*regexp.Regexp
+	reasons       []*regexp.Regexp
+	involvedKinds []*regexp.Regexp
+}
+
+func compileRule(c RuleConfig) (*rule, error) {
+	if c.Name == "" {
+		return nil, errors.New("rule name is required")
+	}
+
+	r := &rule{name: c.Name, receiver: c.Receiver}
+	for _, field := range []struct {
+		patterns []string
+		out      *[]*regexp.Regexp
+	}{
+		{c.Namespaces, &r.namespaces},
+		{c.Types, &r.types},
+		{c.Reasons, &r.reasons},
+		{c.InvolvedKinds, &r.involvedKinds},
+	} {
+		for _, pattern := range field.patterns {
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", c.Name, err)
+			}
+			*field.out = append(*field.out, re)
+		}
+	}
+	return r, nil
+}
+
+func (r *rule) match(n *Notification) bool {
+	return matchAny(r.namespaces, n.Namespace) &&
+		matchAny(r.types, n.Type) &&
+		matchAny(r.reasons, n.Reason) &&
+		matchAny(r.involvedKinds, n.InvolvedKind)
+}
+
+func matchAny(patterns []*regexp.Regexp, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, re := range patterns {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	sentNotifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_exporter_notifications_sent_total",
+		Help: "Notifications successfully delivered to the receiver",
+	}, []string{"receiver"})
+	deadLetters = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_exporter_notifications_dead_letters_total",
+		Help: "Notifications that were not delivered because of errors or the queue overflow",
+	}, []string{"receiver"})
+)
+
+// MustRegisterMetrics registers metrics of notifications in the registry.
+func MustRegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(sentNotifications, deadLetters)
+}
+
+// Notification is a single event matched by the rule.
+type Notification struct {
+	Rule string `json:"rule"`
+
+	UID       string    `json:"uid"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Count     int32     `json:"count"`
+	Timestamp time.Time `json:"timestamp"`
+
+	InvolvedKind      string `json:"involved_kind"`
+	InvolvedName      string `json:"involved_name"`
+	InvolvedNamespace string `json:"involved_namespace,omitempty"`
+	SourceComponent   string `json:"source_component,omitempty"`
+	SourceHost        string `json:"source_host,omitempty"`
+}
+
+func eventToNotification(event *v1.Event) Notification {
+	timestamp := event.LastTimestamp.Time
+	if timestamp.IsZero() {
+		timestamp = event.EventTime.Time
+	}
+	if timestamp.IsZero() {
+		timestamp = event.CreationTimestamp.Time
+	}
+
+	return Notification{
+		UID:               string(event.UID),
+		Namespace:         event.Namespace,
+		Name:              event.Name,
+		Type:              event.Type,
+		Reason:            event.Reason,
+		Message:           event.Message,
+		Count:             event.Count,
+		Timestamp:         timestamp,
+		InvolvedKind:      event.InvolvedObject.Kind,
+		InvolvedName:      event.InvolvedObject.Name,
+		InvolvedNamespace: event.InvolvedObject.Namespace,
+		SourceComponent:   event.Source.Component,
+		SourceHost:        event.Source.Host,
+	}
+}
+
+// Notifier matches incoming events against rules and delivers them to receivers
+// without waiting for the Prometheus rules evaluation.
+type Notifier struct {
+	rules       []*rule
+	receivers   map[string]*receiver
+	maxEventAge time.Duration
+
+	mu sync.Mutex
+	// sent is the last notified count of every event per rule to not notify twice about the same event.
+	sent map[string]sentNotification
+	// sentTTL is for how long to remember sent notifications.
+	sentTTL time.Duration
+	now     func() time.Time
+}
+
+type sentNotification struct {
+	count    int32
+	lastSeen time.Time
+}
+
+// New creates the notifier for the config. Sent notifications are remembered for the sentTTL to deduplicate them.
+func New(config Config, sentTTL time.Duration) (*Notifier, error) {
+	if sentTTL <= 0 {
+		sentTTL = time.Hour
+	}
+
+	maxEventAge := config.MaxEventAge
+	if maxEventAge <= 0 {
+		maxEventAge = DefaultMaxEventAge
+	}
+
+	n := &Notifier{
+		receivers:   make(map[string]*receiver, len(config.Receivers)),
+		maxEventAge: maxEventAge,
+		sent:        make(map[string]sentNotification),
+		sentTTL:     sentTTL,
+		now:         time.Now,
+	}
+
+	for _, c := range config.Receivers {
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := n.receivers[c.Name]; ok {
+			return nil, fmt.Errorf("duplicated receiver %q", c.Name)
+		}
+		n.receivers[c.Name] = newReceiver(c)
+	}
+
+	ruleNames := make(map[string]bool, len(config.Rules))
+	for _, c := range config.Rules {
+		r, err := compileRule(c)
+		if err != nil {
+			return nil, err
+		}
+		// Sent notifications are remembered by rule names, rules with the same name would suppress each other.
+		if ruleNames[r.name] {
+			return nil, fmt.Errorf("duplicated rule %q", r.name)
+		}
+		ruleNames[r.name] = true
+		if _, ok := n.receivers[r.receiver]; !ok {
+			return nil, fmt.Errorf("rule %q: unknown receiver %q", r.name, r.receiver)
+		}
+		n.rules = append(n.rules, r)
+	}
+	return n, nil
+}
+
+// Handle sends a notification for every rule matching the event, unless it was already sent for the same count.
+func (n *Notifier) Handle(event *v1.Event) {
+	if len(n.rules) == 0 {
+		return
+	}
+
+	notification := eventToNotification(event)
+	tooOld := notification.Timestamp.Before(n.now().Add(-n.maxEventAge))
+
+	for _, r := range n.rules {
+		if !r.match(&notification) || !n.firstTime(r.name, &notification) || tooOld {
+			continue
+		}
+
+		notification.Rule = r.name
+		n.receivers[r.receiver].enqueue(notification)
+	}
+}
+
+func (n *Notifier) firstTime(ruleName string, notification *Notification) bool {
+	key := ruleName + "/" + notification.UID
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	previous, ok := n.sent[key]
+	n.sent[key] = sentNotification{count: notification.Count, lastSeen: n.now()}
+	return !ok || previous.count != notification.Count
+}
+
+// Run delivers notifications until the stop channel is closed.
+func (n *Notifier) Run(stopCh <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, r := range n.receivers {
+		wg.Add(1)
+		go func(r *receiver) {
+			defer wg.Done()
+			r.run(stopCh)
+		}(r)
+	}
+
+	ticker := time.NewTicker(n.sentTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.forgetStale()
+		case <-stopCh:
+			wg.Wait()
+			return
+		}
+	}
+}
+
+func (n *Notifier) forgetStale() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	deadline := n.now().Add(-n.sentTTL)
+	for key, sent := range n.sent {
+		if sent.lastSeen.Before(deadline) {
+			delete(n.sent, key)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// standIn is a local webhook server recording request bodies.
+type standIn struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bodies = append(s.bodies, body)
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (s *standIn) requests() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.bodies...)
+}
+
+func testEvent(uid, namespace, reason string, count int32) *v1.Event {
+	return &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{UID: types.UID(uid), Namespace: namespace},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "pod-" + uid, Namespace: namespace},
+		Type:           "Warning",
+		Reason:         reason,
+		Message:        "0/3 nodes are available",
+		Count:          count,
+		LastTimestamp:  metav1.NewTime(time.Now()),
+	}
+}
+
+func testReceiverConfig(name, url, format string) ReceiverConfig {
+	c := DefaultReceiverConfig
+	c.Name = name
+	c.URL = url
+	c.Format = format
+	c.BatchInterval = 10 * time.Millisecond
+	c.MinBackoff = time.Millisecond
+	c.RateLimit = 1000
+	return c
+}
+
+func TestNotifier(t *testing.T) {
+	srv := &standIn{}
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	n, err := New(Config{
+		Receivers: []ReceiverConfig{testReceiverConfig("webhook", httpSrv.URL, JSONFormat)},
+		Rules: []RuleConfig{
+			{Name: "scheduling", Receiver: "webhook", Namespaces: []string{"prod-.*"}, Reasons: []string{"FailedScheduling"}},
+		},
+	}, time.Hour)
+	require.NoError(t, err)
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		n.Run(stopCh)
+		close(done)
+	}()
+	defer func() {
+		close(stopCh)
+		<-done
+	}()
+
+	n.Handle(testEvent("1", "prod-app", "FailedScheduling", 1))
+	// The same count is a duplicate.
+	n.Handle(testEvent("1", "prod-app", "FailedScheduling", 1))
+	// Namespace and reason do not match.
+	n.Handle(testEvent("2", "dev-app", "FailedScheduling", 1))
+	n.Handle(testEvent("3", "prod-app", "Started", 1))
+
+	require.Eventually(t, func() bool { return len(srv.requests()) == 1 }, time.Second, 10*time.Millisecond)
+
+	// The event was repeated and must be notified again.
+	n.Handle(testEvent("1", "prod-app", "FailedScheduling", 2))
+	require.Eventually(t, func() bool { return len(srv.requests()) == 2 }, time.Second, 10*time.Millisecond)
+
+	var payloads []webhookPayload
+	for _, body := range srv.requests() {
+		var payload webhookPayload
+		require.NoError(t, json.Unmarshal(body, &payload))
+		payloads = append(payloads, payload)
+	}
+
+	require.Len(t, payloads[0].Notifications, 1)
+	require.Equal(t, "scheduling", payloads[0].Notifications[0].Rule)
+	require.Equal(t, "pod-1", payloads[0].Notifications[0].InvolvedName)
+	require.Equal(t, int32(2), payloads[1].Notifications[0].Count)
+}
+
+func TestNotifierSkipsOldEvents(t *testing.T) {
+	n, err := New(Config{
+		Receivers: []ReceiverConfig{testReceiverConfig("webhook", "http://localhost", JSONFormat)},
+		Rules:     []RuleConfig{{Name: "all", Receiver: "webhook"}},
+	}, time.Hour)
+	require.NoError(t, err)
+
+	event := testEvent("1", "default", "BackOff", 1)
+	event.LastTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	n.Handle(event)
+
+	require.Len(t, n.receivers["webhook"].queue, 0)
+}
+
+func TestAlertmanagerPayload(t *testing.T) {
+	c := testReceiverConfig("alertmanager", "http://localhost", AlertmanagerFormat)
+	r := newReceiver(c)
+
+	notification := eventToNotification(testEvent("1", "prod-app", "OOMKilling", 3))
+	notification.Rule = "oom"
+
+	body, err := r.payload([]Notification{notification})
+	require.NoError(t, err)
+
+	var alerts []alert
+	require.NoError(t, json.Unmarshal(body, &alerts))
+	require.Len(t, alerts, 1)
+
+	require.Equal(t, map[string]string{
+		"alertname":     "oom",
+		"namespace":     "prod-app",
+		"type":          "Warning",
+		"reason":        "OOMKilling",
+		"involved_kind": "Pod",
+		"involved_name": "pod-1",
+	}, alerts[0].Labels)
+	require.Equal(t, "3", alerts[0].Annotations["count"])
+	require.Equal(t, c.AlertResolveTimeout, alerts[0].EndsAt.Sub(alerts[0].StartsAt))
+}
+
+func TestReceiverRetries(t *testing.T) {
+	srv := &standIn{statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusOK}}
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	sentBefore := testutil.ToFloat64(sentNotifications.WithLabelValues("retries"))
+	deadBefore := testutil.ToFloat64(deadLetters.WithLabelValues("retries"))
+
+	r := newReceiver(testReceiverConfig("retries", httpSrv.URL, JSONFormat))
+	batch := r.deliver(context.Background(), []Notification{{Rule: "test"}})
+	require.Len(t, batch, 0)
+	require.Len(t, srv.requests(), 3)
+	require.Equal(t, float64(1), testutil.ToFloat64(sentNotifications.WithLabelValues("retries"))-sentBefore)
+
+	// All attempts failed, the notification goes to the dead letters.
+	srv.mu.Lock()
+	srv.statuses = []int{500, 500, 500, 500}
+	srv.mu.Unlock()
+	r.deliver(context.Background(), []Notification{{Rule: "test"}})
+	require.Len(t, srv.requests(), 7)
+	require.Equal(t, float64(1), testutil.ToFloat64(deadLetters.WithLabelValues("retries"))-deadBefore)
+
+	// Client errors are not retried.
+	srv.mu.Lock()
+	srv.statuses = []int{http.StatusBadRequest}
+	srv.mu.Unlock()
+	r.deliver(context.Background(), []Notification{{Rule: "test"}})
+	require.Len(t, srv.requests(), 8)
+	require.Equal(t, float64(2), testutil.ToFloat64(deadLetters.WithLabelValues("retries"))-deadBefore)
+}
+
+func TestReceiverFlushesOnStop(t *testing.T) {
+	srv := &standIn{}
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	sentBefore := testutil.ToFloat64(sentNotifications.WithLabelValues("flush"))
+	deadBefore := testutil.ToFloat64(deadLetters.WithLabelValues("flush"))
+
+	c := testReceiverConfig("flush", httpSrv.URL, JSONFormat)
+	c.BatchInterval = time.Hour
+	c.BatchSize = 2
+	r := newReceiver(c)
+	for i := 0; i < 3; i++ {
+		r.enqueue(Notification{Rule: "test"})
+	}
+
+	stopCh := make(chan struct{})
+	close(stopCh)
+	r.run(stopCh)
+
+	// Full batches taken from the queue after the stop are flushed too.
+	require.Len(t, srv.requests(), 2)
+	for i, size := range []int{2, 1} {
+		var payload webhookPayload
+		require.NoError(t, json.Unmarshal(srv.requests()[i], &payload))
+		require.Len(t, payload.Notifications, size)
+	}
+	require.Equal(t, float64(3), testutil.ToFloat64(sentNotifications.WithLabelValues("flush"))-sentBefore)
+
+	// Notifications are sent once on stop, failed ones go to the dead letters.
+	srv.mu.Lock()
+	srv.statuses = []int{http.StatusServiceUnavailable, http.StatusOK}
+	srv.mu.Unlock()
+	r.enqueue(Notification{Rule: "test"})
+	r.run(stopCh)
+
+	require.Len(t, srv.requests(), 3)
+	require.Equal(t, float64(1), testutil.ToFloat64(deadLetters.WithLabelValues("flush"))-deadBefore)
+}
+
+func TestReceiverConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *ReceiverConfig)
+		error  string
+	}{
+		{
+			name:   "zero min backoff",
+			modify: func(c *ReceiverConfig) { c.MinBackoff = 0 },
+			error:  "min backoff must be positive",
+		},
+		{
+			name:   "max backoff below min backoff",
+			modify: func(c *ReceiverConfig) { c.MaxBackoff = c.MinBackoff / 2 },
+			error:  "not greater than max backoff",
+		},
+		{
+			name:   "negative retries",
+			modify: func(c *ReceiverConfig) { c.MaxRetries = -1 },
+			error:  "max retries must not be negative",
+		},
+		{
+			name:   "zero timeout",
+			modify: func(c *ReceiverConfig) { c.Timeout = 0 },
+			error:  "timeout must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testReceiverConfig("webhook", "http://localhost", JSONFormat)
+			tt.modify(&c)
+			err := c.Validate()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.error)
+		})
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	_, err := New(Config{Rules: []RuleConfig{{Name: "orphan", Receiver: "missing"}}}, time.Hour)
+	require.Error(t, err)
+
+	_, err = New(Config{
+		Receivers: []ReceiverConfig{testReceiverConfig("webhook", "http://localhost", JSONFormat)},
+		Rules:     []RuleConfig{{Name: "broken", Receiver: "webhook", Reasons: []string{"("}}},
+	}, time.Hour)
+	require.Error(t, err)
+
+	_, err = New(Config{
+		Receivers: []ReceiverConfig{testReceiverConfig("webhook", "http://localhost", JSONFormat)},
+		Rules:     []RuleConfig{{Name: "oom", Receiver: "webhook"}, {Name: "oom", Receiver: "webhook"}},
+	}, time.Hour)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `duplicated rule "oom"`)
+}
@@ -0,0 +1,250 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
+)
+
+type receiver struct {
+	config  ReceiverConfig
+	client  *http.Client
+	limiter *rate.Limiter
+
+	queue chan Notification
+}
+
+// recoverableError is returned for failures worth retrying, e.g., network errors or 5xx responses.
+type recoverableError struct {
+	error
+}
+
+// webhookPayload is the body for the json format.
+type webhookPayload struct {
+	Notifications []Notification `json:"notifications"`
+}
+
+// alert is the postable alert of the Alertmanager v2 API.
+type alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+func newReceiver(config ReceiverConfig) *receiver {
+	return &receiver{
+		config:  config,
+		client:  &http.Client{Timeout: config.Timeout},
+		limiter: rate.NewLimiter(rate.Limit(config.RateLimit), 1),
+		queue:   make(chan Notification, config.QueueCapacity),
+	}
+}
+
+func (r *receiver) enqueue(n Notification) {
+	select {
+	case r.queue <- n:
+	default:
+		deadLetters.WithLabelValues(r.config.Name).Inc()
+		logging.Logger("notifier").Info("receiver queue is full, dropping notification", "receiver", r.config.Name, "rule", n.Rule)
+	}
+}
+
+func (r *receiver) run(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	ticker := time.NewTicker(r.config.BatchInterval)
+	defer ticker.Stop()
+
+	batch := make([]Notification, 0, r.config.BatchSize)
+	for {
+		select {
+		case n := <-r.queue:
+			batch = append(batch, n)
+			// The queue case may win over the stop, the batch is flushed then instead of being dead-lettered.
+			if len(batch) >= r.config.BatchSize && !stopped(stopCh) {
+				batch = r.deliver(ctx, batch)
+			}
+		case <-ticker.C:
+			if !stopped(stopCh) {
+				batch = r.deliver(ctx, batch)
+			}
+		case <-stopCh:
+			r.flush(batch)
+			return
+		}
+	}
+}
+
+func stopped(stopCh <-chan struct{}) bool {
+	select {
+	case <-stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// flush sends the batch and queued notifications once without retries, so the shutdown takes at most the receiver
+// timeout. Notifications that were not sent in time are counted as dead letters.
+func (r *receiver) flush(batch []Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.Timeout)
+	defer cancel()
+
+drain:
+	for {
+		select {
+		case n := <-r.queue:
+			batch = append(batch, n)
+		default:
+			break drain
+		}
+	}
+
+	for len(batch) > 0 {
+		size := r.config.BatchSize
+		if size > len(batch) {
+			size = len(batch)
+		}
+		r.sendBatch(ctx, batch[:size], 0)
+		batch = batch[size:]
+	}
+}
+
+// deliver sends the batch respecting the rate limit and returns the emptied batch for reuse.
+func (r *receiver) deliver(ctx context.Context, batch []Notification) []Notification {
+	if len(batch) > 0 {
+		r.sendBatch(ctx, batch, r.config.MaxRetries)
+	}
+	return batch[:0]
+}
+
+// sendBatch sends notifications in a single request retrying recoverable errors. Notifications that failed to be sent
+// after all retries are counted as dead letters.
+func (r *receiver) sendBatch(ctx context.Context, batch []Notification, maxRetries int) {
+	body, err := r.payload(batch)
+	if err != nil {
+		logging.Logger("notifier").Error(err, "receiver payload", "receiver", r.config.Name)
+		deadLetters.WithLabelValues(r.config.Name).Add(float64(len(batch)))
+		return
+	}
+
+	backoff := r.config.MinBackoff
+	for attempt := 0; ; attempt++ {
+		if err := r.limiter.Wait(ctx); err != nil {
+			logging.Logger("notifier").Error(err, "send notifications", "receiver", r.config.Name, "attempt", attempt)
+			deadLetters.WithLabelValues(r.config.Name).Add(float64(len(batch)))
+			return
+		}
+
+		err := r.send(ctx, body)
+		if err == nil {
+			sentNotifications.WithLabelValues(r.config.Name).Add(float64(len(batch)))
+			return
+		}
+
+		if _, ok := err.(recoverableError); !ok || attempt >= maxRetries {
+			logging.Logger("notifier").Error(err, "send notifications", "receiver", r.config.Name, "attempt", attempt)
+			deadLetters.WithLabelValues(r.config.Name).Add(float64(len(batch)))
+			return
+		}
+
+		logging.Logger("notifier").Info("send notifications failed, retrying", "receiver", r.config.Name, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			deadLetters.WithLabelValues(r.config.Name).Add(float64(len(batch)))
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > r.config.MaxBackoff {
+			backoff = r.config.MaxBackoff
+		}
+	}
+}
+
+func (r *receiver) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.config.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.config.BearerToken)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(message))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
+
+func (r *receiver) payload(batch []Notification) ([]byte, error) {
+	if r.config.Format != AlertmanagerFormat {
+		return json.Marshal(webhookPayload{Notifications: batch})
+	}
+
+	alerts := make([]alert, 0, len(batch))
+	for _, n := range batch {
+		alerts = append(alerts, alert{
+			Labels: map[string]string{
+				"alertname":     n.Rule,
+				"namespace":     n.Namespace,
+				"type":          n.Type,
+				"reason":        n.Reason,
+				"involved_kind": n.InvolvedKind,
+				"involved_name": n.InvolvedName,
+			},
+			Annotations: map[string]string{
+				"message":          n.Message,
+				"count":            strconv.Itoa(int(n.Count)),
+				"event_uid":        n.UID,
+				"source_component": n.SourceComponent,
+			},
+			StartsAt: n.Timestamp,
+			// Events have no end, so alerts are resolved by timeout if the event is not repeated.
+			EndsAt: n.Timestamp.Add(r.config.AlertResolveTimeout),
+		})
+	}
+	return json.Marshal(alerts)
+}
@@ -0,0 +1,272 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package relabel rewrites sample labels before they are stored, following the semantics of Prometheus
+// relabel_configs.
+package relabel
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// Action is the relabeling action.
+type Action string
+
+const (
+	// Replace sets target_label to replacement if regex matches concatenated source_labels.
+	Replace Action = "replace"
+	// Keep drops samples if regex does not match concatenated source_labels.
+	Keep Action = "keep"
+	// Drop drops samples if regex matches concatenated source_labels.
+	Drop Action = "drop"
+	// LabelMap copies values of labels with names matching regex to labels named after replacement.
+	LabelMap Action = "labelmap"
+	// LabelDrop removes labels with names matching regex.
+	LabelDrop Action = "labeldrop"
+	// HashMod sets target_label to the modulus of the hash of concatenated source_labels.
+	HashMod Action = "hashmod"
+	// Lowercase sets target_label to lowercased concatenated source_labels.
+	Lowercase Action = "lowercase"
+)
+
+// labelMapTarget matches label names with references to regex groups.
+var labelMapTarget = regexp.MustCompile(`^(?:(?:[a-zA-Z_]|\$(?:\{\w+\}|\w+))+\w*)+$`)
+
+// Config is a single relabeling step in the Prometheus relabel_config format.
+type Config struct {
+	SourceLabels []string `yaml:"source_labels,flow,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	Regex        Regexp   `yaml:"regex,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty"`
+	// TargetLabel must be a plain label name, because stored metrics have fixed label names.
+	TargetLabel string `yaml:"target_label,omitempty"`
+	Replacement string `yaml:"replacement,omitempty"`
+	Action      Action `yaml:"action,omitempty"`
+}
+
+// DefaultConfig is applied to every relabel config before unmarshalling, the same as in Prometheus.
+var DefaultConfig = Config{
+	Action:      Replace,
+	Separator:   ";",
+	Regex:       MustNewRegexp("(.*)"),
+	Replacement: "$1",
+}
+
+// UnmarshalYAML sets defaults for omitted fields.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate checks that the config is complete for its action.
+func (c *Config) Validate() error {
+	if c.Regex.Regexp == nil {
+		c.Regex = MustNewRegexp("")
+	}
+
+	switch c.Action {
+	case Replace, HashMod, Lowercase:
+		if !model.LabelName(c.TargetLabel).IsValid() {
+			return fmt.Errorf("relabel action %s: invalid target_label %q", c.Action, c.TargetLabel)
+		}
+		if c.Action == HashMod && c.Modulus == 0 {
+			return fmt.Errorf("relabel action %s: modulus is required", c.Action)
+		}
+	case Keep, Drop:
+	case LabelMap:
+		if !labelMapTarget.MatchString(c.Replacement) {
+			return fmt.Errorf("relabel action %s: invalid replacement %q", c.Action, c.Replacement)
+		}
+	case LabelDrop:
+		if len(c.SourceLabels) > 0 || c.Separator != DefaultConfig.Separator || c.TargetLabel != "" || c.Modulus != 0 ||
+			c.Replacement != DefaultConfig.Replacement {
+			return fmt.Errorf("relabel action %s: only regex is allowed", c.Action)
+		}
+	default:
+		return fmt.Errorf("unknown relabel action %q", c.Action)
+	}
+	return nil
+}
+
+// Regexp is the regular expression anchored at both ends, as in Prometheus.
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// NewRegexp compiles the anchored regular expression.
+func NewRegexp(s string) (Regexp, error) {
+	re, err := regexp.Compile("^(?:" + s + ")$")
+	return Regexp{Regexp: re, original: s}, err
+}
+
+// MustNewRegexp is like NewRegexp but panics on invalid expressions.
+func MustNewRegexp(s string) Regexp {
+	re, err := NewRegexp(s)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// UnmarshalYAML compiles the regular expression.
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	r, err := NewRegexp(s)
+	if err != nil {
+		return fmt.Errorf("invalid relabel regex %q: %w", s, err)
+	}
+	*re = r
+	return nil
+}
+
+// MarshalYAML returns the original expression.
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	if re.Regexp == nil {
+		return nil, nil
+	}
+	return re.original, nil
+}
+
+// Process applies configs to labels in order and returns the result without empty labels. It returns false
+// if the sample is dropped. Passed labels are not modified.
+func Process(labels map[string]string, configs ...*Config) (map[string]string, bool) {
+	result := make(map[string]string, len(labels))
+	for name, value := range labels {
+		result[name] = value
+	}
+
+	for _, c := range configs {
+		if !c.apply(result) {
+			return nil, false
+		}
+	}
+
+	for name, value := range result {
+		if value == "" {
+			delete(result, name)
+		}
+	}
+	return result, true
+}
+
+func (c *Config) apply(labels map[string]string) bool {
+	values := make([]string, 0, len(c.SourceLabels))
+	for _, name := range c.SourceLabels {
+		values = append(values, labels[name])
+	}
+	value := strings.Join(values, c.Separator)
+
+	switch c.Action {
+	case Replace:
+		indexes := c.Regex.FindStringSubmatchIndex(value)
+		if indexes == nil {
+			break
+		}
+		replacement := c.Regex.ExpandString(nil, c.Replacement, value, indexes)
+		if len(replacement) == 0 {
+			delete(labels, c.TargetLabel)
+			break
+		}
+		labels[c.TargetLabel] = string(replacement)
+	case Keep:
+		if !c.Regex.MatchString(value) {
+			return false
+		}
+	case Drop:
+		if c.Regex.MatchString(value) {
+			return false
+		}
+	case HashMod:
+		sum := md5.Sum([]byte(value))
+		labels[c.TargetLabel] = fmt.Sprint(binary.BigEndian.Uint64(sum[8:]) % c.Modulus)
+	case Lowercase:
+		labels[c.TargetLabel] = strings.ToLower(value)
+	case LabelMap:
+		// Names that are not valid label names are skipped, as in LabelNames.
+		mapped := make(map[string]string)
+		for name, v := range labels {
+			if !c.Regex.MatchString(name) {
+				continue
+			}
+			if target := c.Regex.ReplaceAllString(name, c.Replacement); model.LabelName(target).IsValid() {
+				mapped[target] = v
+			}
+		}
+		for name, v := range mapped {
+			labels[name] = v
+		}
+	case LabelDrop:
+		for name := range labels {
+			if c.Regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	}
+	return true
+}
+
+// LabelNames returns names of labels samples with the passed label names may have after relabeling. Label names
+// do not depend on label values, so they are known before any sample is processed. The order of passed names is
+// kept, and new names are appended in the order of configs.
+func LabelNames(names []string, configs ...*Config) []string {
+	result := append([]string(nil), names...)
+	has := func(name string) bool {
+		for _, n := range result {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	add := func(name string) {
+		if model.LabelName(name).IsValid() && !has(name) {
+			result = append(result, name)
+		}
+	}
+
+	for _, c := range configs {
+		switch c.Action {
+		case Replace, HashMod, Lowercase:
+			add(c.TargetLabel)
+		case LabelMap:
+			for _, name := range append([]string(nil), result...) {
+				if c.Regex.MatchString(name) {
+					add(c.Regex.ReplaceAllString(name, c.Replacement))
+				}
+			}
+		case LabelDrop:
+			kept := result[:0]
+			for _, name := range result {
+				if !c.Regex.MatchString(name) {
+					kept = append(kept, name)
+				}
+			}
+			result = kept
+		}
+	}
+	return result
+}
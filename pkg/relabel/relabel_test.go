@@ -0,0 +1,231 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relabel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func parseConfigs(t *testing.T, content string) []*Config {
+	t.Helper()
+	var configs []*Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(content), &configs))
+	return configs
+}
+
+func TestProcess(t *testing.T) {
+	labels := map[string]string{
+		"type":               "Warning",
+		"reason":             "BackOff",
+		"involved_kind":      "Pod",
+		"involved_namespace": "Prod-Payments",
+		"message":            "Back-off restarting failed container",
+	}
+
+	tests := []struct {
+		name     string
+		configs  string
+		expected map[string]string
+	}{
+		{
+			name: "replace",
+			configs: `
+- source_labels: [involved_namespace]
+  regex: (.+)-(.+)
+  target_label: team
+  replacement: $2`,
+			expected: map[string]string{"team": "Payments"},
+		},
+		{
+			name: "replace without match keeps labels",
+			configs: `
+- source_labels: [involved_kind]
+  regex: Node
+  target_label: involved_kind
+  replacement: node`,
+			expected: map[string]string{},
+		},
+		{
+			name: "replace with empty value removes the label",
+			configs: `
+- target_label: message
+  replacement: ""`,
+			expected: map[string]string{"message": ""},
+		},
+		{
+			name: "replace with separator",
+			configs: `
+- source_labels: [involved_kind, reason]
+  separator: /
+  target_label: key`,
+			expected: map[string]string{"key": "Pod/BackOff"},
+		},
+		{
+			name: "keep",
+			configs: `
+- source_labels: [type]
+  regex: Warning
+  action: keep`,
+			expected: map[string]string{},
+		},
+		{
+			name: "drop",
+			configs: `
+- source_labels: [reason]
+  regex: Back.*
+  action: drop`,
+			expected: nil,
+		},
+		{
+			name: "keep is anchored",
+			configs: `
+- source_labels: [type]
+  regex: Warn
+  action: keep`,
+			expected: nil,
+		},
+		{
+			name: "labelmap",
+			configs: `
+- regex: involved_(.+)
+  replacement: object_$1
+  action: labelmap`,
+			expected: map[string]string{"object_kind": "Pod", "object_namespace": "Prod-Payments"},
+		},
+		{
+			name: "labelmap to invalid name",
+			configs: `
+- regex: involved_kind(.*)
+  replacement: $1
+  action: labelmap`,
+			expected: map[string]string{},
+		},
+		{
+			name: "labeldrop",
+			configs: `
+- regex: message|involved_.+
+  action: labeldrop`,
+			expected: map[string]string{"message": "", "involved_kind": "", "involved_namespace": ""},
+		},
+		{
+			name: "hashmod",
+			configs: `
+- source_labels: [involved_namespace]
+  modulus: 8
+  target_label: shard
+  action: hashmod`,
+			expected: map[string]string{"shard": "4"},
+		},
+		{
+			name: "lowercase",
+			configs: `
+- source_labels: [involved_namespace]
+  target_label: involved_namespace
+  action: lowercase`,
+			expected: map[string]string{"involved_namespace": "prod-payments"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, keep := Process(labels, parseConfigs(t, tt.configs)...)
+			if tt.expected == nil {
+				require.False(t, keep)
+				return
+			}
+			require.True(t, keep)
+
+			// Expected labels are changes of the input labels, empty values are removed labels.
+			expected := make(map[string]string)
+			for name, value := range labels {
+				expected[name] = value
+			}
+			for name, value := range tt.expected {
+				if value == "" {
+					delete(expected, name)
+					continue
+				}
+				expected[name] = value
+			}
+			require.Equal(t, expected, result)
+		})
+	}
+
+	require.Equal(t, "Prod-Payments", labels["involved_namespace"], "input labels must not be modified")
+}
+
+func TestLabelNames(t *testing.T) {
+	configs := parseConfigs(t, `
+- source_labels: [involved_namespace]
+  target_label: namespace
+- regex: involved_(.+)
+  replacement: object_$1
+  action: labelmap
+- regex: involved_.+|message
+  action: labeldrop
+- source_labels: [namespace]
+  modulus: 4
+  target_label: shard
+  action: hashmod`)
+
+	require.Equal(t,
+		[]string{"type", "reason", "namespace", "object_kind", "object_namespace", "shard"},
+		LabelNames([]string{"type", "reason", "involved_kind", "involved_namespace", "message"}, configs...),
+	)
+}
+
+func TestLabelNamesMatchProcess(t *testing.T) {
+	configs := parseConfigs(t, `
+- regex: involved_(kind|namespace)(.*)
+  replacement: $2
+  action: labelmap`)
+
+	labels := map[string]string{"type": "Warning", "involved_kind": "Pod", "involved_namespace": "default"}
+	result, keep := Process(labels, configs...)
+	require.True(t, keep)
+
+	names := make([]string, 0, len(result))
+	for name := range result {
+		names = append(names, name)
+	}
+	require.ElementsMatch(t, LabelNames([]string{"type", "involved_kind", "involved_namespace"}, configs...), names)
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs string
+		error   string
+	}{
+		{name: "unknown action", configs: `[{action: unknown}]`, error: `unknown relabel action "unknown"`},
+		{name: "invalid regex", configs: `[{regex: "("}]`, error: "invalid relabel regex"},
+		{name: "replace without target", configs: `[{source_labels: [type]}]`, error: "invalid target_label"},
+		{name: "templated target", configs: `[{target_label: "${1}"}]`, error: "invalid target_label"},
+		{name: "hashmod without modulus", configs: `[{action: hashmod, target_label: shard}]`, error: "modulus is required"},
+		{name: "labelmap to invalid name", configs: `[{action: labelmap, replacement: "1-$1"}]`, error: "invalid replacement"},
+		{name: "labeldrop with target", configs: `[{action: labeldrop, target_label: type}]`, error: "only regex is allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configs []*Config
+			err := yaml.UnmarshalStrict([]byte(tt.configs), &configs)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.error)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config describes a single Prometheus remote_write endpoint.
+type Config struct {
+	URL            string            `yaml:"url"`
+	Interval       time.Duration     `yaml:"interval,omitempty"`
+	Timeout        time.Duration     `yaml:"timeout,omitempty"`
+	ExternalLabels map[string]string `yaml:"external_labels,omitempty"`
+
+	BasicAuth       *BasicAuth `yaml:"basic_auth,omitempty"`
+	BearerToken     string     `yaml:"bearer_token,omitempty"`
+	BearerTokenFile string     `yaml:"bearer_token_file,omitempty"`
+	TLSConfig       TLSConfig  `yaml:"tls_config,omitempty"`
+
+	Queue QueueConfig `yaml:"queue,omitempty"`
+}
+
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+}
+
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// QueueConfig controls how snapshots waiting to be sent are retried.
+type QueueConfig struct {
+	// Capacity is the max number of snapshots in the queue. The oldest snapshot is dropped on overflow.
+	Capacity   int           `yaml:"capacity,omitempty"`
+	MaxRetries int           `yaml:"max_retries,omitempty"`
+	MinBackoff time.Duration `yaml:"min_backoff,omitempty"`
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+// DefaultConfig is applied to every remote_write section before unmarshalling.
+var DefaultConfig = Config{
+	Interval: 30 * time.Second,
+	Timeout:  10 * time.Second,
+	Queue: QueueConfig{
+		Capacity:   10,
+		MaxRetries: 5,
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: 10 * time.Second,
+	},
+}
+
+// UnmarshalYAML sets defaults for omitted fields.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate checks that the config is complete.
+func (c *Config) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("remote write url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("remote write url %q: scheme must be http or https", c.URL)
+	}
+	if c.Interval <= 0 {
+		return errors.New("remote write interval must be positive")
+	}
+	if c.BasicAuth != nil && (c.BearerToken != "" || c.BearerTokenFile != "") {
+		return errors.New("at most one of basic_auth, bearer_token and bearer_token_file must be configured")
+	}
+	if c.BearerToken != "" && c.BearerTokenFile != "" {
+		return errors.New("at most one of bearer_token and bearer_token_file must be configured")
+	}
+	if c.Queue.Capacity <= 0 {
+		return errors.New("remote write queue capacity must be positive")
+	}
+	if c.Queue.MaxRetries < 0 {
+		return errors.New("remote write max retries must not be negative")
+	}
+	if c.Queue.MinBackoff <= 0 || c.Queue.MaxBackoff < c.Queue.MinBackoff {
+		return errors.New("remote write min backoff must be positive and not greater than max backoff")
+	}
+	return nil
+}
+
+func (c *Config) httpClient() (*http.Client, error) {
+	tlsConfig, err := c.TLSConfig.build()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport, Timeout: c.Timeout}, nil
+}
+
+// authorize sets auth headers. Secrets are read from files on every request to pick up rotated credentials.
+func (c *Config) authorize(req *http.Request) error {
+	switch {
+	case c.BasicAuth != nil:
+		password := c.BasicAuth.Password
+		if c.BasicAuth.PasswordFile != "" {
+			content, err := os.ReadFile(c.BasicAuth.PasswordFile)
+			if err != nil {
+				return fmt.Errorf("read basic auth password: %w", err)
+			}
+			password = strings.TrimSpace(string(content))
+		}
+		req.SetBasicAuth(c.BasicAuth.Username, password)
+	case c.BearerTokenFile != "":
+		content, err := os.ReadFile(c.BearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("read bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(content)))
+	case c.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+	return nil
+}
+
+func (c *TLSConfig) build() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec
+	}
+
+	if c.CAFile != "" {
+		content, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found in %q", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
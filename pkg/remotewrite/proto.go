@@ -0,0 +1,86 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers from the prometheus.WriteRequest protobuf definition.
+// The message is small enough to encode it by hand instead of depending on the whole Prometheus module.
+const (
+	writeRequestTimeseriesField = 1
+
+	timeSeriesLabelsField  = 1
+	timeSeriesSamplesField = 2
+
+	labelNameField  = 1
+	labelValueField = 2
+
+	sampleValueField     = 1
+	sampleTimestampField = 2
+)
+
+type label struct {
+	Name  string
+	Value string
+}
+
+type sample struct {
+	Value       float64
+	TimestampMs int64
+}
+
+type timeSeries struct {
+	// Labels must be sorted by name.
+	Labels  []label
+	Samples []sample
+}
+
+func marshalWriteRequest(series []timeSeries) []byte {
+	var buf []byte
+	for _, ts := range series {
+		buf = protowire.AppendTag(buf, writeRequestTimeseriesField, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, marshalTimeSeries(ts))
+	}
+	return buf
+}
+
+func marshalTimeSeries(ts timeSeries) []byte {
+	var buf []byte
+	for _, l := range ts.Labels {
+		var labelBuf []byte
+		labelBuf = protowire.AppendTag(labelBuf, labelNameField, protowire.BytesType)
+		labelBuf = protowire.AppendString(labelBuf, l.Name)
+		labelBuf = protowire.AppendTag(labelBuf, labelValueField, protowire.BytesType)
+		labelBuf = protowire.AppendString(labelBuf, l.Value)
+
+		buf = protowire.AppendTag(buf, timeSeriesLabelsField, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, labelBuf)
+	}
+
+	for _, s := range ts.Samples {
+		var sampleBuf []byte
+		sampleBuf = protowire.AppendTag(sampleBuf, sampleValueField, protowire.Fixed64Type)
+		sampleBuf = protowire.AppendFixed64(sampleBuf, math.Float64bits(s.Value))
+		sampleBuf = protowire.AppendTag(sampleBuf, sampleTimestampField, protowire.VarintType)
+		sampleBuf = protowire.AppendVarint(sampleBuf, uint64(s.TimestampMs))
+
+		buf = protowire.AppendTag(buf, timeSeriesSamplesField, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, sampleBuf)
+	}
+	return buf
+}
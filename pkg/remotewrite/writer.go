@@ -0,0 +1,304 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
+)
+
+const (
+	userAgent = "events_exporter"
+	// maxErrorBodyLen limits how much of the remote error response gets to the logs.
+	maxErrorBodyLen = 256
+)
+
+var (
+	sentSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_exporter_remote_write_samples_total",
+		Help: "Samples successfully pushed to the remote storage",
+	}, []string{"remote"})
+	failedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_exporter_remote_write_failed_requests_total",
+		Help: "Remote write requests failed after all retries",
+	}, []string{"remote"})
+	droppedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_exporter_remote_write_dropped_requests_total",
+		Help: "Remote write requests dropped because of the queue overflow",
+	}, []string{"remote"})
+)
+
+// MustRegisterMetrics registers metrics of remote write in the registry.
+func MustRegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(sentSamples, failedRequests, droppedRequests)
+}
+
+// Writer periodically pushes snapshots of gathered metrics to a Prometheus remote_write endpoint.
+type Writer struct {
+	config   Config
+	client   *http.Client
+	gatherer prometheus.Gatherer
+	remote   string
+
+	queue chan writeRequest
+	now   func() time.Time
+}
+
+type writeRequest struct {
+	body    []byte
+	samples int
+}
+
+// recoverableError is returned for failures worth retrying, e.g., network errors or 5xx responses.
+type recoverableError struct {
+	error
+}
+
+// NewWriter creates the remote writer for the config. Metrics are taken from the gatherer.
+func NewWriter(config Config, gatherer prometheus.Gatherer) (*Writer, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := config.httpClient()
+	if err != nil {
+		return nil, fmt.Errorf("remote write client: %w", err)
+	}
+
+	u, _ := url.Parse(config.URL)
+	return &Writer{
+		config:   config,
+		client:   client,
+		gatherer: gatherer,
+		remote:   u.Host,
+		queue:    make(chan writeRequest, config.Queue.Capacity),
+		now:      time.Now,
+	}, nil
+}
+
+// Run pushes snapshots until the stop channel is closed. The last snapshot is pushed on exit.
+func (w *Writer) Run(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go w.sendQueued(ctx)
+
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.push()
+		case <-stopCh:
+			w.flush()
+			return
+		}
+	}
+}
+
+func (w *Writer) push() {
+	request, err := w.snapshot()
+	if err != nil {
+		logging.Logger("remotewrite").Error(err, "remote write snapshot", "remote", w.remote)
+		return
+	}
+
+	select {
+	case w.queue <- request:
+		return
+	default:
+	}
+
+	// The queue is full. Fresh data is more valuable, so the oldest snapshot goes away.
+	select {
+	case <-w.queue:
+		droppedRequests.WithLabelValues(w.remote).Inc()
+	default:
+	}
+	select {
+	case w.queue <- request:
+	default:
+		droppedRequests.WithLabelValues(w.remote).Inc()
+	}
+}
+
+// flush sends the last snapshot once without retries, e.g., before the cluster is destroyed.
+func (w *Writer) flush() {
+	request, err := w.snapshot()
+	if err != nil {
+		logging.Logger("remotewrite").Error(err, "remote write snapshot", "remote", w.remote)
+		return
+	}
+
+	if err := w.send(context.Background(), request); err != nil {
+		failedRequests.WithLabelValues(w.remote).Inc()
+		logging.Logger("remotewrite").Error(err, "remote write final push", "remote", w.remote)
+	}
+}
+
+func (w *Writer) sendQueued(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case request := <-w.queue:
+			w.sendWithRetries(ctx, request)
+		}
+	}
+}
+
+func (w *Writer) sendWithRetries(ctx context.Context, request writeRequest) {
+	backoff := w.config.Queue.MinBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := w.send(ctx, request)
+		if err == nil {
+			return
+		}
+
+		if _, ok := err.(recoverableError); !ok || attempt >= w.config.Queue.MaxRetries {
+			failedRequests.WithLabelValues(w.remote).Inc()
+			logging.Logger("remotewrite").Error(err, "remote write", "remote", w.remote, "attempt", attempt)
+			return
+		}
+
+		logging.Logger("remotewrite").Info("remote write failed, retrying", "remote", w.remote, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > w.config.Queue.MaxBackoff {
+			backoff = w.config.Queue.MaxBackoff
+		}
+	}
+}
+
+func (w *Writer) send(ctx context.Context, request writeRequest) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(request.body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	if err := w.config.authorize(req); err != nil {
+		return err
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		sentSamples.WithLabelValues(w.remote).Add(float64(request.samples))
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLen))
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
+
+// snapshot converts gathered gauges and counters to the compressed remote write request.
+func (w *Writer) snapshot() (writeRequest, error) {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		return writeRequest{}, err
+	}
+
+	nowMs := w.now().UnixNano() / int64(time.Millisecond)
+	series := make([]timeSeries, 0)
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var value float64
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				value = metric.GetGauge().GetValue()
+			case dto.MetricType_COUNTER:
+				value = metric.GetCounter().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = metric.GetUntyped().GetValue()
+			default:
+				continue
+			}
+
+			timestampMs := nowMs
+			if metric.TimestampMs != nil {
+				timestampMs = metric.GetTimestampMs()
+			}
+
+			series = append(series, timeSeries{
+				Labels:  w.labels(family.GetName(), metric.GetLabel()),
+				Samples: []sample{{Value: value, TimestampMs: timestampMs}},
+			})
+		}
+	}
+
+	return writeRequest{
+		body:    snappy.Encode(nil, marshalWriteRequest(series)),
+		samples: len(series),
+	}, nil
+}
+
+// labels merges metric labels with external labels. Metric labels take precedence like in Prometheus.
+func (w *Writer) labels(name string, pairs []*dto.LabelPair) []label {
+	labels := make([]label, 0, len(pairs)+len(w.config.ExternalLabels)+1)
+	labels = append(labels, label{Name: "__name__", Value: name})
+
+	seen := make(map[string]struct{}, len(pairs))
+	for _, pair := range pairs {
+		// Empty labels are equal to absent labels in Prometheus, remote storages reject them.
+		if pair.GetValue() == "" {
+			continue
+		}
+		labels = append(labels, label{Name: pair.GetName(), Value: pair.GetValue()})
+		seen[pair.GetName()] = struct{}{}
+	}
+
+	for labelName, labelValue := range w.config.ExternalLabels {
+		if _, ok := seen[labelName]; ok {
+			continue
+		}
+		labels = append(labels, label{Name: labelName, Value: labelValue})
+	}
+
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// receiver is a minimal remote write receiver decoding incoming requests.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests [][]timeSeries
+	headers  []http.Header
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.headers = append(r.headers, req.Header.Clone())
+
+	compressed, _ := io.ReadAll(req.Body)
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.requests = append(r.requests, unmarshalWriteRequest(body))
+
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func unmarshalWriteRequest(b []byte) []timeSeries {
+	var series []timeSeries
+	forEachField(b, func(_ protowire.Number, v []byte) {
+		var ts timeSeries
+		forEachField(v, func(num protowire.Number, v []byte) {
+			switch num {
+			case timeSeriesLabelsField:
+				var l label
+				forEachField(v, func(num protowire.Number, v []byte) {
+					if num == labelNameField {
+						l.Name = string(v)
+					} else {
+						l.Value = string(v)
+					}
+				})
+				ts.Labels = append(ts.Labels, l)
+			case timeSeriesSamplesField:
+				var s sample
+				for len(v) > 0 {
+					num, typ, n := protowire.ConsumeTag(v)
+					v = v[n:]
+					if num == sampleValueField && typ == protowire.Fixed64Type {
+						bits, n := protowire.ConsumeFixed64(v)
+						s.Value = math.Float64frombits(bits)
+						v = v[n:]
+					} else {
+						ts, n := protowire.ConsumeVarint(v)
+						s.TimestampMs = int64(ts)
+						v = v[n:]
+					}
+				}
+				ts.Samples = append(ts.Samples, s)
+			}
+		})
+		series = append(series, ts)
+	})
+	return series
+}
+
+func forEachField(b []byte, f func(protowire.Number, []byte)) {
+	for len(b) > 0 {
+		num, _, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		v, n := protowire.ConsumeBytes(b)
+		b = b[n:]
+		f(num, v)
+	}
+}
+
+func testGatherer(t *testing.T) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_metric", Help: "Test"}, []string{"name", "empty"})
+	gauge.WithLabelValues("test", "").Set(5)
+	require.NoError(t, registry.Register(gauge))
+	return registry
+}
+
+func testWriter(t *testing.T, url string, modify func(*Config)) *Writer {
+	config := DefaultConfig
+	config.URL = url
+	config.Queue.MinBackoff = time.Millisecond
+	if modify != nil {
+		modify(&config)
+	}
+
+	writer, err := NewWriter(config, testGatherer(t))
+	require.NoError(t, err)
+	writer.now = func() time.Time { return time.Unix(1600000000, 0) }
+	return writer
+}
+
+func TestWriterPush(t *testing.T) {
+	recv := &receiver{}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	writer := testWriter(t, srv.URL, func(c *Config) {
+		c.ExternalLabels = map[string]string{"cluster": "ci", "name": "overridden"}
+		c.BasicAuth = &BasicAuth{Username: "user", Password: "secret"}
+	})
+
+	request, err := writer.snapshot()
+	require.NoError(t, err)
+	writer.sendWithRetries(context.Background(), request)
+
+	recv.mu.Lock()
+	defer recv.mu.Unlock()
+
+	require.Equal(t, [][]timeSeries{{
+		{
+			Labels: []label{
+				{Name: "__name__", Value: "test_metric"},
+				{Name: "cluster", Value: "ci"},
+				{Name: "name", Value: "test"},
+			},
+			Samples: []sample{{Value: 5, TimestampMs: 1600000000000}},
+		},
+	}}, recv.requests)
+
+	require.Equal(t, "snappy", recv.headers[0].Get("Content-Encoding"))
+	require.Equal(t, "0.1.0", recv.headers[0].Get("X-Prometheus-Remote-Write-Version"))
+
+	req := &http.Request{Header: recv.headers[0]}
+	username, password, ok := req.BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "user", username)
+	require.Equal(t, "secret", password)
+}
+
+func TestWriterRetries(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Statuses []int
+		Attempts int
+	}{
+		{
+			Name:     "Recoverable errors are retried",
+			Statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusNoContent},
+			Attempts: 3,
+		},
+		{
+			Name:     "Bad request is not retried",
+			Statuses: []int{http.StatusBadRequest},
+			Attempts: 1,
+		},
+		{
+			Name:     "Retries are limited",
+			Statuses: []int{500, 500, 500, 500, 500, 500, 500, 500},
+			Attempts: DefaultConfig.Queue.MaxRetries + 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			recv := &receiver{statuses: tc.Statuses}
+			srv := httptest.NewServer(recv)
+			defer srv.Close()
+
+			writer := testWriter(t, srv.URL, func(c *Config) {
+				c.BearerToken = "token"
+			})
+
+			request, err := writer.snapshot()
+			require.NoError(t, err)
+			writer.sendWithRetries(context.Background(), request)
+
+			recv.mu.Lock()
+			defer recv.mu.Unlock()
+			require.Len(t, recv.requests, tc.Attempts)
+			require.Equal(t, "Bearer token", recv.headers[0].Get("Authorization"))
+		})
+	}
+}
+
+func TestWriterQueueOverflow(t *testing.T) {
+	writer := testWriter(t, "http://localhost", func(c *Config) {
+		c.Queue.Capacity = 2
+	})
+
+	for i := int64(0); i < 3; i++ {
+		timestamp := time.Unix(1600000000+i, 0)
+		writer.now = func() time.Time { return timestamp }
+		writer.push()
+	}
+
+	require.Len(t, writer.queue, 2)
+	oldest := <-writer.queue
+
+	body, err := snappy.Decode(nil, oldest.body)
+	require.NoError(t, err)
+	require.Equal(t, int64(1600000001000), unmarshalWriteRequest(body)[0].Samples[0].TimestampMs)
+}
+
+func TestConfigValidate(t *testing.T) {
+	config := DefaultConfig
+	config.URL = "ftp://localhost"
+	require.Error(t, config.Validate())
+
+	config.URL = "https://localhost/api/v1/write"
+	require.NoError(t, config.Validate())
+
+	config.BasicAuth = &BasicAuth{Username: "user"}
+	config.BearerToken = "token"
+	require.Error(t, config.Validate())
+
+	config = DefaultConfig
+	config.URL = "https://localhost/api/v1/write"
+	config.Queue.MinBackoff = 0
+	require.Error(t, config.Validate())
+
+	config.Queue.MinBackoff = time.Second
+	config.Queue.MaxBackoff = time.Millisecond
+	require.Error(t, config.Validate())
+}
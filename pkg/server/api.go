@@ -0,0 +1,247 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+	defaultSort      = "-last_update"
+)
+
+// labelAliases are short query parameters for the most used event labels.
+var labelAliases = map[string]string{
+	"namespace": "involved_namespace",
+	"kind":      "involved_kind",
+	"name":      "involved_name",
+}
+
+// SamplesSource provides samples currently held in memory, e.g., the metrics vault.
+type SamplesSource interface {
+	Samples() []vault.StoredSample
+}
+
+// SampleResponse is a single sample in the query API response.
+type SampleResponse struct {
+	Metric     string            `json:"metric"`
+	Labels     map[string]string `json:"labels"`
+	Value      float64           `json:"value"`
+	FirstSeen  time.Time         `json:"first_seen"`
+	LastUpdate time.Time         `json:"last_update"`
+	// TTL is the effective TTL of the sample in seconds.
+	TTL float64 `json:"ttl_seconds"`
+	// ExpiresIn is the number of seconds until the sample is removed unless it is updated.
+	ExpiresIn float64 `json:"expires_in_seconds"`
+	UID       string  `json:"uid,omitempty"`
+}
+
+// SamplesResponse is a page of samples matching the query.
+type SamplesResponse struct {
+	Total   int              `json:"total"`
+	Offset  int              `json:"offset"`
+	Limit   int              `json:"limit"`
+	Samples []SampleResponse `json:"samples"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// samplesQuery is the parsed query of the events API.
+//
+// Parameters:
+//   - metric, uid: exact match of the metric name and the source uid;
+//   - any label name or its alias (namespace, kind, name): exact match of the label value;
+//   - sort: last_update (default), first_seen, expires_in, value, metric or a label name, prefixed with "-" for
+//     the descending order;
+//   - limit and offset for paging.
+type samplesQuery struct {
+	metric string
+	uid    string
+	labels map[string]string
+
+	sortBy     string
+	descending bool
+
+	limit  int
+	offset int
+}
+
+func parseSamplesQuery(r *http.Request) (samplesQuery, error) {
+	q := samplesQuery{labels: make(map[string]string), limit: defaultPageLimit}
+	sortBy := defaultSort
+
+	for name, values := range r.URL.Query() {
+		value := values[len(values)-1]
+		var err error
+
+		switch name {
+		case "metric":
+			q.metric = value
+		case "uid":
+			q.uid = value
+		case "sort":
+			sortBy = value
+		case "limit":
+			q.limit, err = strconv.Atoi(value)
+			if err == nil && (q.limit <= 0 || q.limit > maxPageLimit) {
+				err = fmt.Errorf("must be between 1 and %d", maxPageLimit)
+			}
+		case "offset":
+			q.offset, err = strconv.Atoi(value)
+			if err == nil && q.offset < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		default:
+			if alias, ok := labelAliases[name]; ok {
+				name = alias
+			}
+			q.labels[name] = value
+		}
+
+		if err != nil {
+			return q, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	q.sortBy = strings.TrimPrefix(sortBy, "-")
+	q.descending = q.sortBy != sortBy
+	return q, nil
+}
+
+func (q samplesQuery) match(s vault.StoredSample) bool {
+	if q.metric != "" && s.Metric != q.metric {
+		return false
+	}
+	if q.uid != "" && s.ID != q.uid {
+		return false
+	}
+	for name, value := range q.labels {
+		if labelValue, ok := s.Labels[name]; !ok || labelValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (q samplesQuery) less(a, b vault.StoredSample) bool {
+	switch q.sortBy {
+	case "last_update":
+		return a.LastUpdate.Before(b.LastUpdate)
+	case "first_seen":
+		return a.FirstSeen.Before(b.FirstSeen)
+	case "expires_in":
+		return a.ExpiresAt.Before(b.ExpiresAt)
+	case "value":
+		return a.Value < b.Value
+	case "metric":
+		return a.Metric < b.Metric
+	default:
+		return a.Labels[q.sortBy] < b.Labels[q.sortBy]
+	}
+}
+
+// NewEventsAPI returns the JSON API handler listing samples of the source, e.g., for debugging fired alerts.
+func NewEventsAPI(source SamplesSource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "only GET is allowed"})
+			return
+		}
+
+		q, err := parseSamplesQuery(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+
+		var matched []vault.StoredSample
+		for _, s := range source.Samples() {
+			if q.match(s) {
+				matched = append(matched, s)
+			}
+		}
+
+		sort.Slice(matched, func(i, j int) bool {
+			a, b := matched[i], matched[j]
+			if q.descending {
+				a, b = b, a
+			}
+			if q.less(a, b) || q.less(b, a) {
+				return q.less(a, b)
+			}
+			// Samples are gathered from maps, the tie-break keeps pages stable between requests.
+			if matched[i].Metric != matched[j].Metric {
+				return matched[i].Metric < matched[j].Metric
+			}
+			return labelsKey(matched[i]) < labelsKey(matched[j])
+		})
+
+		response := SamplesResponse{Total: len(matched), Offset: q.offset, Limit: q.limit, Samples: []SampleResponse{}}
+		now := time.Now()
+		for i := q.offset; i < len(matched) && i < q.offset+q.limit; i++ {
+			response.Samples = append(response.Samples, newSampleResponse(matched[i], now))
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	})
+}
+
+func newSampleResponse(s vault.StoredSample, now time.Time) SampleResponse {
+	return SampleResponse{
+		Metric:     s.Metric,
+		Labels:     s.Labels,
+		Value:      s.Value,
+		FirstSeen:  s.FirstSeen,
+		LastUpdate: s.LastUpdate,
+		TTL:        s.TTL.Seconds(),
+		ExpiresIn:  s.ExpiresAt.Sub(now).Seconds(),
+		UID:        s.ID,
+	}
+}
+
+func labelsKey(s vault.StoredSample) string {
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	for _, name := range names {
+		key.WriteString(s.Labels[name])
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logging.Logger("server").Error(err, "write JSON response")
+	}
+}
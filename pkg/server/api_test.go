@@ -0,0 +1,106 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+type staticSamples []vault.StoredSample
+
+func (s staticSamples) Samples() []vault.StoredSample {
+	return s
+}
+
+func TestEventsAPI(t *testing.T) {
+	now := time.Now()
+	sample := func(uid, namespace, reason string, lastUpdate time.Duration) vault.StoredSample {
+		return vault.StoredSample{
+			Metric:     "kube_event_info",
+			Labels:     map[string]string{"involved_namespace": namespace, "involved_kind": "Pod", "reason": reason},
+			Value:      1,
+			FirstSeen:  now.Add(-time.Hour),
+			LastUpdate: now.Add(lastUpdate),
+			ExpiresAt:  now.Add(lastUpdate + time.Hour),
+			ID:         uid,
+		}
+	}
+	api := NewEventsAPI(staticSamples{
+		sample("1", "default", "BackOff", -3*time.Minute),
+		sample("2", "default", "FailedScheduling", -time.Minute),
+		sample("3", "kube-system", "BackOff", -2*time.Minute),
+	})
+
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		total    int
+		expected []string
+	}{
+		{name: "all newest first", query: "", status: http.StatusOK, total: 3, expected: []string{"2", "3", "1"}},
+		{name: "namespace alias", query: "namespace=default", status: http.StatusOK, total: 2, expected: []string{"2", "1"}},
+		{name: "label filters", query: "kind=Pod&reason=BackOff", status: http.StatusOK, total: 2, expected: []string{"3", "1"}},
+		{name: "uid", query: "uid=3", status: http.StatusOK, total: 1, expected: []string{"3"}},
+		{name: "unknown label", query: "node=worker", status: http.StatusOK, total: 0, expected: []string{}},
+		{name: "sort ascending", query: "sort=last_update", status: http.StatusOK, total: 3, expected: []string{"1", "3", "2"}},
+		{name: "sort by label", query: "sort=-involved_namespace", status: http.StatusOK, total: 3, expected: []string{"3", "1", "2"}},
+		{name: "paging", query: "limit=1&offset=1", status: http.StatusOK, total: 3, expected: []string{"3"}},
+		{name: "offset out of range", query: "offset=10", status: http.StatusOK, total: 3, expected: []string{}},
+		{name: "invalid limit", query: "limit=0", status: http.StatusBadRequest},
+		{name: "invalid offset", query: "offset=first", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/events?"+tt.query, nil))
+			require.Equal(t, tt.status, w.Code)
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var response SamplesResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			require.Equal(t, tt.total, response.Total)
+
+			uids := []string{}
+			for _, s := range response.Samples {
+				uids = append(uids, s.UID)
+			}
+			require.Equal(t, tt.expected, uids)
+		})
+	}
+}
+
+func TestEventsAPIExpiresIn(t *testing.T) {
+	api := NewEventsAPI(staticSamples{{Metric: "test", TTL: 2 * time.Hour, ExpiresAt: time.Now().Add(time.Hour)}})
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/events", nil))
+
+	var response SamplesResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Len(t, response.Samples, 1)
+	require.InDelta(t, time.Hour.Seconds(), response.Samples[0].ExpiresIn, 5)
+	require.Equal(t, (2 * time.Hour).Seconds(), response.Samples[0].TTL)
+}
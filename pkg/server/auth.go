@@ -0,0 +1,237 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
+)
+
+// authenticator decides whether the request is allowed to reach handlers.
+type authenticator interface {
+	// authenticate returns the HTTP status to respond with if the request is not allowed, and 0 otherwise.
+	authenticate(r *http.Request) int
+	// challenge is the WWW-Authenticate header value for unauthorized requests.
+	challenge() string
+}
+
+// withAuth protects the handler with the authenticator. Health checks are always allowed for probes.
+func withAuth(auth authenticator, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			if status := auth.authenticate(r); status != 0 {
+				if status == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", auth.challenge())
+				}
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// basicAuth checks user passwords against bcrypt hashes. Checked passwords are cached, because bcrypt is
+// deliberately slow and scrapes happen often.
+type basicAuth struct {
+	users map[string]string
+
+	mu    sync.Mutex
+	cache map[string]bool
+}
+
+const (
+	basicAuthCacheSize = 100
+	// unknownUserHash is a valid bcrypt hash to compare passwords of unknown users with.
+	unknownUserHash = "$2y$10$QOauhQNbBCuQDKes6eFzPeMqBSjb7Mr5DUmpZ/VcEd00UAV/LDeSi"
+)
+
+func newBasicAuth(users map[string]string) *basicAuth {
+	return &basicAuth{users: users, cache: make(map[string]bool)}
+}
+
+func (a *basicAuth) challenge() string {
+	return `Basic realm="events_exporter"`
+}
+
+func (a *basicAuth) authenticate(r *http.Request) int {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return http.StatusUnauthorized
+	}
+
+	hash, known := a.users[user]
+	if !known {
+		// Compare anyway to not reveal existing users by the response time.
+		hash = unknownUserHash
+	}
+
+	key := cacheKey(user, password, hash)
+
+	a.mu.Lock()
+	allowed, cached := a.cache[key]
+	a.mu.Unlock()
+
+	if !cached {
+		allowed = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil && known
+
+		a.mu.Lock()
+		// Random passwords must not grow the cache infinitely.
+		if len(a.cache) >= basicAuthCacheSize {
+			a.cache = make(map[string]bool)
+		}
+		a.cache[key] = allowed
+		a.mu.Unlock()
+	}
+
+	if !allowed {
+		return http.StatusUnauthorized
+	}
+	return 0
+}
+
+// kubernetesAuth authenticates bearer tokens with the TokenReview API and authorizes users with
+// the SubjectAccessReview API.
+type kubernetesAuth struct {
+	client kubernetes.Interface
+	config KubernetesAuthConfig
+
+	mu        sync.Mutex
+	decisions map[string]decision
+	now       func() time.Time
+}
+
+type decision struct {
+	status  int
+	expires time.Time
+}
+
+func newKubernetesAuth(client kubernetes.Interface, config KubernetesAuthConfig) *kubernetesAuth {
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = defaultKubernetesAuthCacheTTL
+	}
+	return &kubernetesAuth{client: client, config: config, decisions: make(map[string]decision), now: time.Now}
+}
+
+func (a *kubernetesAuth) challenge() string {
+	return `Bearer realm="events_exporter"`
+}
+
+func (a *kubernetesAuth) authenticate(r *http.Request) int {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return http.StatusUnauthorized
+	}
+
+	key := cacheKey(token, r.URL.Path)
+	now := a.now()
+
+	a.mu.Lock()
+	cached, ok := a.decisions[key]
+	a.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.status
+	}
+
+	status, err := a.review(r.Context(), token, r.URL.Path)
+	if err != nil {
+		// Do not cache API errors, the next request will try again.
+		logging.Logger("server").Error(err, "kubernetes auth")
+		return http.StatusInternalServerError
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for k, d := range a.decisions {
+		if !now.Before(d.expires) {
+			delete(a.decisions, k)
+		}
+	}
+	a.decisions[key] = decision{status: status, expires: now.Add(a.config.CacheTTL)}
+	return status
+}
+
+func (a *kubernetesAuth) review(ctx context.Context, token, path string) (int, error) {
+	tokenReview, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("token review: %w", err)
+	}
+	if !tokenReview.Status.Authenticated {
+		return http.StatusUnauthorized, nil
+	}
+
+	user := tokenReview.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+
+	spec := authorizationv1.SubjectAccessReviewSpec{
+		User:   user.Username,
+		Groups: user.Groups,
+		UID:    user.UID,
+		Extra:  extra,
+	}
+	if attrs := a.config.ResourceAttributes; attrs != nil {
+		spec.ResourceAttributes = &authorizationv1.ResourceAttributes{
+			Namespace:   attrs.Namespace,
+			Verb:        "get",
+			Group:       attrs.APIGroup,
+			Version:     attrs.APIVersion,
+			Resource:    attrs.Resource,
+			Subresource: attrs.Subresource,
+			Name:        attrs.Name,
+		}
+	} else {
+		spec.NonResourceAttributes = &authorizationv1.NonResourceAttributes{Path: path, Verb: "get"}
+	}
+
+	accessReview, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: spec,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("subject access review: %w", err)
+	}
+	if !accessReview.Status.Allowed {
+		return http.StatusForbidden, nil
+	}
+	return 0, nil
+}
+
+// cacheKey hashes secrets to not keep them in memory as is.
+func cacheKey(parts ...string) string {
+	hasher := sha256.New()
+	for _, part := range parts {
+		hasher.Write([]byte(part))
+		hasher.Write([]byte{0})
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	handler := withAuth(newBasicAuth(map[string]string{"prometheus": string(hash)}), okHandler)
+
+	tests := []struct {
+		name     string
+		path     string
+		user     string
+		password string
+		expected int
+	}{
+		{name: "valid", path: "/metrics", user: "prometheus", password: "secret", expected: http.StatusOK},
+		{name: "wrong password", path: "/metrics", user: "prometheus", password: "wrong", expected: http.StatusUnauthorized},
+		{name: "unknown user", path: "/metrics", user: "grafana", password: "secret", expected: http.StatusUnauthorized},
+		{name: "no credentials", path: "/metrics", expected: http.StatusUnauthorized},
+		{name: "health check", path: "/healthz", expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Run twice to check cached decisions too.
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+				if tt.user != "" {
+					r.SetBasicAuth(tt.user, tt.password)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				require.Equal(t, tt.expected, w.Code)
+				if tt.expected == http.StatusUnauthorized {
+					require.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+				}
+			}
+		})
+	}
+}
+
+func TestKubernetesAuth(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	tokenReviews := 0
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tokenReviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		review.Status.Authenticated = review.Spec.Token != "invalid"
+		review.Status.User.Username = "system:serviceaccount:monitoring:" + review.Spec.Token
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		require.Equal(t, "/metrics", review.Spec.NonResourceAttributes.Path)
+		review.Status.Allowed = review.Spec.User == "system:serviceaccount:monitoring:prometheus"
+		return true, review, nil
+	})
+
+	auth := newKubernetesAuth(client, KubernetesAuthConfig{})
+	now := time.Now()
+	auth.now = func() time.Time { return now }
+	handler := withAuth(auth, okHandler)
+
+	tests := []struct {
+		name     string
+		header   string
+		expected int
+	}{
+		{name: "allowed", header: "Bearer prometheus", expected: http.StatusOK},
+		{name: "forbidden", header: "Bearer grafana", expected: http.StatusForbidden},
+		{name: "not authenticated", header: "Bearer invalid", expected: http.StatusUnauthorized},
+		{name: "no token", header: "", expected: http.StatusUnauthorized},
+		{name: "not a bearer token", header: "Basic cHJvbWV0aGV1cw==", expected: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tt.expected, w.Code)
+		})
+	}
+	require.Equal(t, 3, tokenReviews)
+
+	// Decisions are cached until the TTL passes.
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer prometheus")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, 3, tokenReviews)
+
+	now = now.Add(defaultKubernetesAuthCacheTTL)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, 4, tokenReviews)
+}
@@ -15,58 +15,132 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/prometheus/common/log"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
 )
 
 // MetricsServer is a http server which serves prometheus metrics from the metrics vault.
 type MetricsServer struct {
 	srv *http.Server
+	mux *http.ServeMux
+
+	certs *certReloader
 }
 
-// NewMetricsServer returns a metrics server instance.
-func NewMetricsServer() *MetricsServer {
-	return &MetricsServer{srv: &http.Server{}}
+// Endpoint routes a path to the gatherer metrics are exposed from.
+type Endpoint struct {
+	Path     string
+	Gatherer prometheus.Gatherer
+	// Registerer is where the handler instrumentation is registered with the handler label of the path, so endpoints
+	// can share the registerer. The handler is not instrumented if it is nil.
+	Registerer prometheus.Registerer
 }
 
-// Start runs metrics server with typical exporter handlers.
-func (m *MetricsServer) Start(address string, errorCh chan error) {
-	http.Handle("/metrics", promhttp.Handler())
+// NewMetricsServer returns a metrics server instance exposing metrics of the endpoints and secured according
+// to the web config. The Kubernetes client is only required for the Kubernetes authentication.
+func NewMetricsServer(endpoints []Endpoint, webConfig *WebConfig, client kubernetes.Interface) (*MetricsServer, error) {
+	m := &MetricsServer{mux: http.NewServeMux()}
+	m.srv = &http.Server{Handler: m.mux}
+
+	paths := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if !strings.HasPrefix(endpoint.Path, "/") || endpoint.Path == "/" || endpoint.Path == "/healthz" {
+			return nil, fmt.Errorf("invalid metrics endpoint path %q", endpoint.Path)
+		}
+		for _, path := range paths {
+			if path == endpoint.Path {
+				return nil, fmt.Errorf("duplicated metrics endpoint path %q", endpoint.Path)
+			}
+		}
+		paths = append(paths, endpoint.Path)
 
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		var handler http.Handler = promhttp.HandlerFor(endpoint.Gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
+		if endpoint.Registerer != nil {
+			registerer := prometheus.WrapRegistererWith(prometheus.Labels{"handler": endpoint.Path}, endpoint.Registerer)
+			handler = promhttp.InstrumentMetricHandler(registerer, handler)
+		}
+		m.mux.Handle(endpoint.Path, handler)
+	}
+
+	m.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	})
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		_, err := fmt.Fprintf(w, `<!DOCTYPE html>
-			<title>Events Exporter</title>
-			<h1>Events Exporter</h1>
-			<p><a href=%q>Metrics</a></p>`,
-			"/metrics")
+	m.mux.Handle("/", uiHandler(paths))
+
+	if webConfig.TLSServerConfig != nil {
+		certs, err := newCertReloader(webConfig.TLSServerConfig)
 		if err != nil {
-			log.Warnf("Error while sending a response for the '/' path: %v", err)
-			return
+			return nil, err
 		}
-	})
+		m.certs = certs
+		m.srv.TLSConfig = certs.TLSConfig()
+	}
+
+	switch {
+	case len(webConfig.BasicAuthUsers) > 0:
+		m.srv.Handler = withAuth(newBasicAuth(webConfig.BasicAuthUsers), m.srv.Handler)
+	case webConfig.KubernetesAuth != nil:
+		if client == nil {
+			return nil, errors.New("kubernetes client is required for kubernetes_auth")
+		}
+		m.srv.Handler = withAuth(newKubernetesAuth(client, *webConfig.KubernetesAuth), m.srv.Handler)
+	}
+	return m, nil
+}
+
+// Handle registers the handler for the pattern, e.g., to serve an API along with metrics.
+// Handlers are protected by the authentication of the server.
+func (m *MetricsServer) Handle(pattern string, handler http.Handler) {
+	m.mux.Handle(pattern, handler)
+}
+
+// Handler returns the root handler of the server.
+func (m *MetricsServer) Handler() http.Handler {
+	return m.srv.Handler
+}
 
+// Start runs metrics server with typical exporter handlers.
+func (m *MetricsServer) Start(address string, errorCh chan error) {
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		errorCh <- err
 		return
 	}
 
-	log.Infof("start exporting metrics on %q", address)
-	errorCh <- m.srv.Serve(listener)
+	logging.Logger("server").Info("start exporting metrics", "address", address, "tls", m.certs != nil)
+	errorCh <- m.Serve(listener)
+}
+
+// Serve accepts connections on the listener, TLS connections if it is configured.
+func (m *MetricsServer) Serve(listener net.Listener) error {
+	if m.certs != nil {
+		// Certificates are picked on every handshake by GetConfigForClient, and http.Server.ServeTLS does not accept
+		// configs without certificates in older Go versions.
+		listener = tls.NewListener(listener, m.srv.TLSConfig)
+	}
+	return m.srv.Serve(listener)
+}
+
+// RegisterOnShutdown registers the function to call on Close, e.g., to stop long-running requests.
+func (m *MetricsServer) RegisterOnShutdown(f func()) {
+	m.srv.RegisterOnShutdown(f)
 }
 
 func (m *MetricsServer) Close() {
-	log.Info("closing metrics server ...")
+	logging.Logger("server").Info("closing metrics server")
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
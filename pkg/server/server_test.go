@@ -0,0 +1,125 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nabokihms/events_exporter/pkg/kube"
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+func TestMetricsServerEndpoints(t *testing.T) {
+	events := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_events_total", Help: "Test"})
+	events.MustRegister(counter)
+	counter.Inc()
+
+	internal := prometheus.NewRegistry()
+
+	// Servers with separate gatherers and muxes coexist.
+	for i := 0; i < 2; i++ {
+		m, err := NewMetricsServer([]Endpoint{
+			{Path: "/metrics", Gatherer: events, Registerer: internal},
+			{Path: "/internal/metrics", Gatherer: internal, Registerer: internal},
+		}, &WebConfig{}, nil)
+		require.NoError(t, err)
+
+		body := get(t, m, "/metrics")
+		require.Contains(t, body, "test_events_total 1")
+		require.NotContains(t, body, "promhttp_metric_handler_requests_total")
+
+		body = get(t, m, "/internal/metrics")
+		require.NotContains(t, body, "test_events_total")
+		// Scrapes of endpoints are counted apart.
+		require.Contains(t, body, `promhttp_metric_handler_requests_total{code="200",handler="/metrics"}`)
+		require.Contains(t, body, `promhttp_metric_handler_requests_total{code="200",handler="/internal/metrics"}`)
+		// Runtime metrics are only exposed if registered in the passed gatherer.
+		require.NotContains(t, body, "go_goroutines")
+
+		body = get(t, m, "/")
+		require.Contains(t, body, `href="/internal/metrics"`)
+	}
+}
+
+func TestMetricsServerInvalidEndpoints(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	for _, endpoints := range [][]Endpoint{
+		{{Path: "metrics", Gatherer: registry}},
+		{{Path: "/healthz", Gatherer: registry}},
+		{{Path: "/metrics", Gatherer: registry}, {Path: "/metrics", Gatherer: registry}},
+	} {
+		_, err := NewMetricsServer(endpoints, &WebConfig{}, nil)
+		require.Error(t, err)
+	}
+}
+
+func get(t *testing.T, m *MetricsServer, path string) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+type staticStatus struct {
+	kube.SourceStatus
+	mappings []vault.MappingStatus
+}
+
+func (s staticStatus) Status() kube.SourceStatus {
+	return s.SourceStatus
+}
+
+func (s staticStatus) MappingsStatus() []vault.MappingStatus {
+	return s.mappings
+}
+
+func TestMetricsServerUI(t *testing.T) {
+	m, err := NewMetricsServer([]Endpoint{{Path: "/metrics", Gatherer: prometheus.NewRegistry()}}, &WebConfig{}, nil)
+	require.NoError(t, err)
+
+	status := staticStatus{
+		SourceStatus: kube.SourceStatus{Synced: true, LastError: "connection refused"},
+		mappings:     []vault.MappingStatus{{Mapping: vault.Mapping{Name: "kube_event_info", TTL: time.Hour}, Series: 3}},
+	}
+	m.Handle("/api/v1/status", NewStatusAPI(status, status))
+
+	body := get(t, m, "/")
+	require.Contains(t, body, `<a href="/metrics">/metrics</a>`)
+	require.Contains(t, body, "static/app.js")
+
+	require.Contains(t, get(t, m, "/static/app.js"), "api/v1/events")
+
+	var response StatusResponse
+	require.NoError(t, json.Unmarshal([]byte(get(t, m, "/api/v1/status")), &response))
+	require.Equal(t, status.SourceStatus, response.Source)
+	require.Equal(t, []MappingResponse{{Name: "kube_event_info", Type: vault.GaugeType, TTLSeconds: 3600, Series: 3}}, response.Mappings)
+
+	for _, path := range []string{"/unknown", "/static/", "/index.html"} {
+		w := httptest.NewRecorder()
+		m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.NotEqual(t, http.StatusOK, w.Code, path)
+	}
+}
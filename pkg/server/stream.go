@@ -0,0 +1,208 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+const (
+	// streamBufferSize is the number of samples a client can lag behind before samples are dropped.
+	streamBufferSize   = 256
+	streamPingInterval = 15 * time.Second
+)
+
+var (
+	streamClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "events_exporter_stream_clients",
+		Help: "Clients connected to the events stream",
+	})
+	streamDroppedSamples = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "events_exporter_stream_dropped_samples_total",
+		Help: "Samples not delivered to slow stream clients",
+	})
+)
+
+// MustRegisterMetrics registers metrics of the server in the registry.
+func MustRegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(streamClients, streamDroppedSamples)
+}
+
+// EventsStream broadcasts stored samples to clients with Server-Sent Events. Publishing never blocks, samples are
+// dropped for clients that do not keep up.
+type EventsStream struct {
+	mu      sync.RWMutex
+	clients map[*streamClient]struct{}
+
+	// done is closed to disconnect all clients, e.g., on the server shutdown.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type streamClient struct {
+	query   samplesQuery
+	samples chan vault.StoredSample
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// streamDrop notifies the client about samples it missed.
+type streamDrop struct {
+	Dropped int `json:"dropped"`
+}
+
+// NewEventsStream returns the stream without clients.
+func NewEventsStream() *EventsStream {
+	return &EventsStream{clients: make(map[*streamClient]struct{}), done: make(chan struct{})}
+}
+
+// Close disconnects all clients. The server shutdown does not cancel contexts of active requests, so it would
+// wait for stream clients to leave otherwise.
+func (s *EventsStream) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// Listening reports whether the stream has clients. It implements vault.StoreListener.
+func (s *EventsStream) Listening() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.clients) > 0
+}
+
+// OnStore publishes the stored sample. It implements vault.StoreListener.
+func (s *EventsStream) OnStore(sample vault.StoredSample) {
+	s.Publish(sample)
+}
+
+// Publish sends the sample to every client whose filter matches it.
+func (s *EventsStream) Publish(sample vault.StoredSample) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for client := range s.clients {
+		if !client.query.match(sample) {
+			continue
+		}
+
+		select {
+		case client.samples <- sample:
+		default:
+			client.mu.Lock()
+			client.dropped++
+			client.mu.Unlock()
+			streamDroppedSamples.Inc()
+		}
+	}
+}
+
+// ServeHTTP streams samples to the client until it disconnects. Query parameters filter samples the same way as
+// for the events API.
+func (s *EventsStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "streaming is not supported"})
+		return
+	}
+
+	query, err := parseSamplesQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	client := &streamClient{query: query, samples: make(chan vault.StoredSample, streamBufferSize)}
+	s.subscribe(client)
+	defer s.unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-ping.C:
+			err = writeComment(w, "ping")
+		case sample := <-client.samples:
+			err = client.writeDropped(w)
+			if err == nil {
+				err = writeEvent(w, "sample", newSampleResponse(sample, time.Now()))
+			}
+		}
+		if err != nil {
+			logging.Logger("server").V(1).Info("events stream client disconnected", "reason", err.Error())
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *EventsStream) subscribe(client *streamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[client] = struct{}{}
+	streamClients.Inc()
+}
+
+func (s *EventsStream) unsubscribe(client *streamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, client)
+	streamClients.Dec()
+}
+
+// writeDropped tells the client how many samples were dropped since the last sample it received.
+func (c *streamClient) writeDropped(w http.ResponseWriter) error {
+	c.mu.Lock()
+	dropped := c.dropped
+	c.dropped = 0
+	c.mu.Unlock()
+
+	if dropped == 0 {
+		return nil
+	}
+	return writeEvent(w, "dropped", streamDrop{Dropped: dropped})
+}
+
+func writeEvent(w http.ResponseWriter, event string, data interface{}) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, content)
+	return err
+}
+
+func writeComment(w http.ResponseWriter, comment string) error {
+	_, err := fmt.Fprintf(w, ": %s\n\n", comment)
+	return err
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+func streamSample(uid, namespace string) vault.StoredSample {
+	return vault.StoredSample{
+		Metric: "kube_event_info",
+		Labels: map[string]string{"involved_namespace": namespace},
+		Value:  1,
+		ID:     uid,
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	stream := NewEventsStream()
+	srv := httptest.NewServer(stream)
+	defer srv.Close()
+	require.False(t, stream.Listening())
+
+	response, err := http.Get(srv.URL + "?namespace=prod")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+	require.True(t, stream.Listening())
+
+	// The client is subscribed once headers are sent.
+	stream.Publish(streamSample("1", "dev"))
+	stream.Publish(streamSample("2", "prod"))
+
+	reader := bufio.NewReader(response.Body)
+	event, data := readStreamEvent(t, reader)
+	require.Equal(t, "sample", event)
+
+	var sample SampleResponse
+	require.NoError(t, json.Unmarshal([]byte(data), &sample))
+	require.Equal(t, "2", sample.UID)
+
+	srv.CloseClientConnections()
+	require.Eventually(t, func() bool {
+		stream.mu.RLock()
+		defer stream.mu.RUnlock()
+		return len(stream.clients) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestEventsStreamClosedOnShutdown(t *testing.T) {
+	stream := NewEventsStream()
+	m, err := NewMetricsServer(nil, &WebConfig{}, nil)
+	require.NoError(t, err)
+	m.Handle("/api/v1/events/stream", stream)
+	m.RegisterOnShutdown(stream.Close)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- m.Serve(listener) }()
+
+	response, err := http.Get("http://" + listener.Addr().String() + "/api/v1/events/stream")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	// The open stream must not hold the shutdown until its timeout.
+	closed := make(chan struct{})
+	go func() {
+		m.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server was not closed while a stream was open")
+	}
+	require.ErrorIs(t, <-serveErr, http.ErrServerClosed)
+}
+
+func TestEventsStreamDropsForSlowClients(t *testing.T) {
+	stream := NewEventsStream()
+	client := &streamClient{samples: make(chan vault.StoredSample, 1)}
+	stream.subscribe(client)
+	defer stream.unsubscribe(client)
+
+	before := testutil.ToFloat64(streamDroppedSamples)
+
+	// Publishing must not block even if the client does not read.
+	for i := 0; i < 3; i++ {
+		stream.Publish(streamSample("1", "default"))
+	}
+	require.Equal(t, 2.0, testutil.ToFloat64(streamDroppedSamples)-before)
+
+	w := httptest.NewRecorder()
+	require.NoError(t, client.writeDropped(w))
+	require.Equal(t, "event: dropped\ndata: {\"dropped\":2}\n\n", w.Body.String())
+
+	// The counter is reset after the client was notified.
+	w = httptest.NewRecorder()
+	require.NoError(t, client.writeDropped(w))
+	require.Empty(t, w.Body.String())
+}
+
+func readStreamEvent(t *testing.T, reader *bufio.Reader) (event, data string) {
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
+)
+
+// certReloader serves certificates from files and reloads them once files are changed, e.g., rotated by cert-manager.
+type certReloader struct {
+	config *TLSServerConfig
+
+	mu        sync.Mutex
+	modTime   time.Time
+	tlsConfig *tls.Config
+}
+
+func newCertReloader(config *TLSServerConfig) (*certReloader, error) {
+	r := &certReloader{config: config}
+	if _, err := r.current(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the server config which picks up the latest certificates on every handshake.
+func (r *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current()
+		},
+	}
+}
+
+func (r *certReloader) current() (*tls.Config, error) {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tlsConfig != nil && !modTime.After(r.modTime) {
+		return r.tlsConfig, nil
+	}
+
+	tlsConfig, err := r.load()
+	if err != nil {
+		if r.tlsConfig != nil {
+			// Files can be in the middle of the rotation, keep serving the previous certificate.
+			logging.Logger("server").Error(err, "reload TLS certificates, keep serving the previous ones")
+			return r.tlsConfig, nil
+		}
+		return nil, err
+	}
+
+	if r.tlsConfig != nil {
+		logging.Logger("server").Info("TLS certificates reloaded")
+	}
+	r.tlsConfig = tlsConfig
+	r.modTime = modTime
+	return tlsConfig, nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.config.CertFile, r.config.KeyFile, r.config.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("stat TLS file: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if r.config.MinVersion != "" {
+		tlsConfig.MinVersion = tlsVersions[r.config.MinVersion]
+	}
+
+	if r.config.ClientCAFile != "" {
+		content, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found in %q", r.config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	if r.config.ClientAuthType != "" {
+		tlsConfig.ClientAuth = clientAuthTypes[r.config.ClientAuthType]
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/nabokihms/events_exporter/pkg/kube"
+	"github.com/nabokihms/events_exporter/pkg/logging"
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+// uiFS contains the web UI for on-call engineers without kubectl access. The page polls the events and status APIs.
+//
+//go:embed ui
+var uiFS embed.FS
+
+var indexTemplate = template.Must(template.ParseFS(uiFS, "ui/index.html"))
+
+// StatusSource reports whether events are delivered from the cluster.
+type StatusSource interface {
+	Status() kube.SourceStatus
+}
+
+// MappingsSource reports registered mappings and their series counts.
+type MappingsSource interface {
+	MappingsStatus() []vault.MappingStatus
+}
+
+// MappingResponse is a registered mapping in the status API response.
+type MappingResponse struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	LabelNames []string `json:"labels"`
+	TTLSeconds float64  `json:"ttl_seconds"`
+	Series     int      `json:"series"`
+}
+
+// StatusResponse is the exporter status shown in the web UI.
+type StatusResponse struct {
+	Source   kube.SourceStatus `json:"source"`
+	Mappings []MappingResponse `json:"mappings"`
+}
+
+// NewStatusAPI returns the JSON API handler reporting the state of the events source and mappings.
+func NewStatusAPI(source StatusSource, mappings MappingsSource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := StatusResponse{Source: source.Status(), Mappings: []MappingResponse{}}
+		for _, m := range mappings.MappingsStatus() {
+			metricType := m.Type
+			if metricType == "" {
+				metricType = vault.GaugeType
+			}
+			response.Mappings = append(response.Mappings, MappingResponse{
+				Name:       m.Name,
+				Type:       metricType,
+				LabelNames: m.LabelNames,
+				TTLSeconds: m.TTL.Seconds(),
+				Series:     m.Series,
+			})
+		}
+		writeJSON(w, http.StatusOK, response)
+	})
+}
+
+// uiHandler serves the index page with links to metrics endpoints and static assets of the UI.
+func uiHandler(paths []string) http.Handler {
+	static, err := fs.Sub(uiFS, "ui")
+	if err != nil {
+		panic(err)
+	}
+	staticHandler := http.FileServer(http.FS(static))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/static/") && !strings.HasSuffix(r.URL.Path, "/"):
+			staticHandler.ServeHTTP(w, r)
+			return
+		case r.URL.Path != "/":
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := indexTemplate.Execute(w, struct{ Endpoints []string }{paths}); err != nil {
+			logging.Logger("server").Error(err, "send the index page")
+		}
+	})
+}
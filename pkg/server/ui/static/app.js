@@ -0,0 +1,145 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+"use strict";
+
+const refreshInterval = 10000;
+const pageLimit = 1000;
+
+// expiries keep the time samples expire at to count down between refreshes.
+let expiries = [];
+
+function formatTime(value) {
+  const date = new Date(value);
+  return date.getTime() > 0 ? date.toLocaleString() : "";
+}
+
+function formatDuration(seconds) {
+  if (seconds <= 0) {
+    return "expired";
+  }
+  seconds = Math.floor(seconds);
+  const hours = Math.floor(seconds / 3600);
+  const minutes = Math.floor((seconds % 3600) / 60);
+  return (hours > 0 ? hours + "h " : "") + minutes + "m " + (seconds % 60) + "s";
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+async function fetchJSON(url) {
+  const response = await fetch(url, {credentials: "same-origin"});
+  if (!response.ok) {
+    throw new Error(url + ": " + response.status + " " + response.statusText);
+  }
+  return response.json();
+}
+
+async function refreshStatus() {
+  const status = await fetchJSON("api/v1/status");
+
+  const state = document.getElementById("sync-state");
+  state.textContent = status.source.synced ? "synced" : "syncing";
+  state.className = "badge " + (status.source.synced ? "ok" : "pending");
+  document.getElementById("synced-at").textContent = status.source.synced ? "since " + formatTime(status.source.synced_at) : "";
+
+  document.getElementById("last-error").hidden = !status.source.last_error;
+  document.getElementById("last-error-message").textContent = status.source.last_error || "";
+  document.getElementById("last-error-at").textContent = status.source.last_error ? "at " + formatTime(status.source.last_error_at) : "";
+
+  const mappings = document.getElementById("mappings");
+  mappings.replaceChildren();
+  const metric = document.getElementById("metric");
+  const selected = metric.value;
+  metric.replaceChildren();
+
+  for (const mapping of status.mappings) {
+    const row = mappings.insertRow();
+    cell(row, mapping.name);
+    cell(row, mapping.type);
+    cell(row, formatDuration(mapping.ttl_seconds));
+    cell(row, mapping.series);
+
+    metric.add(new Option(mapping.name, mapping.name, false, mapping.name === selected));
+  }
+}
+
+async function refreshEvents() {
+  const params = new URLSearchParams();
+  for (const [name, value] of new FormData(document.getElementById("filters"))) {
+    if (value) {
+      params.set(name, value);
+    }
+  }
+  params.set("limit", pageLimit);
+
+  const response = await fetchJSON("api/v1/events?" + params);
+  document.getElementById("total").textContent = response.total > response.samples.length
+    ? "Showing " + response.samples.length + " of " + response.total + " events"
+    : response.total + " events";
+
+  const samples = document.getElementById("samples");
+  samples.replaceChildren();
+  const now = Date.now();
+  expiries = [];
+
+  for (const sample of response.samples) {
+    const labels = sample.labels;
+    const row = samples.insertRow();
+    cell(row, labels.involved_namespace || "");
+    cell(row, (labels.involved_kind || "") + "/" + (labels.involved_name || ""));
+    cell(row, labels.type || "", labels.type === "Warning" ? "warning" : "");
+    cell(row, labels.reason || "");
+    cell(row, labels.message || "");
+    cell(row, sample.value);
+    cell(row, formatTime(sample.first_seen));
+    cell(row, formatTime(sample.last_update));
+    const expiry = cell(row, "");
+    expiry.title = "TTL " + formatDuration(sample.ttl_seconds);
+    expiries.push({cell: expiry, at: now + sample.expires_in_seconds * 1000});
+  }
+  countDown();
+}
+
+function countDown() {
+  const now = Date.now();
+  for (const expiry of expiries) {
+    expiry.cell.textContent = formatDuration((expiry.at - now) / 1000);
+  }
+}
+
+async function refresh() {
+  try {
+    await refreshStatus();
+    await refreshEvents();
+  } catch (e) {
+    document.getElementById("total").textContent = "Failed to load: " + e.message;
+  }
+}
+
+document.getElementById("filters").addEventListener("submit", (e) => {
+  e.preventDefault();
+  refreshEvents().catch((err) => {
+    document.getElementById("total").textContent = "Failed to load: " + err.message;
+  });
+});
+
+refresh();
+setInterval(refresh, refreshInterval);
+setInterval(countDown, 1000);
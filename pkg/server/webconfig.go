@@ -0,0 +1,134 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// WebConfig secures the metrics server. The format is compatible with the Prometheus exporter-toolkit web config
+// with the additional Kubernetes authentication section.
+type WebConfig struct {
+	TLSServerConfig *TLSServerConfig `yaml:"tls_server_config,omitempty"`
+	// BasicAuthUsers maps user names to bcrypt hashes of their passwords.
+	BasicAuthUsers map[string]string     `yaml:"basic_auth_users,omitempty"`
+	KubernetesAuth *KubernetesAuthConfig `yaml:"kubernetes_auth,omitempty"`
+}
+
+// TLSServerConfig describes the server certificate and the verification of client certificates.
+type TLSServerConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile enables mTLS, client certificates are verified by this CA.
+	ClientCAFile   string `yaml:"client_ca_file,omitempty"`
+	ClientAuthType string `yaml:"client_auth_type,omitempty"`
+	MinVersion     string `yaml:"min_version,omitempty"`
+}
+
+// KubernetesAuthConfig checks bearer tokens with the TokenReview API and authorizes users with
+// the SubjectAccessReview API, like kube-rbac-proxy does.
+type KubernetesAuthConfig struct {
+	// ResourceAttributes are checked instead of the request path if set.
+	ResourceAttributes *ResourceAttributes `yaml:"resource_attributes,omitempty"`
+	// CacheTTL is for how long to cache authentication and authorization decisions.
+	CacheTTL time.Duration `yaml:"cache_ttl,omitempty"`
+}
+
+// ResourceAttributes is the resource users must be allowed to get to access the server.
+type ResourceAttributes struct {
+	Namespace   string `yaml:"namespace,omitempty"`
+	APIGroup    string `yaml:"api_group,omitempty"`
+	APIVersion  string `yaml:"api_version,omitempty"`
+	Resource    string `yaml:"resource"`
+	Subresource string `yaml:"subresource,omitempty"`
+	Name        string `yaml:"name,omitempty"`
+}
+
+const defaultKubernetesAuthCacheTTL = time.Minute
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// LoadWebConfig reads the web config file. Empty path means the plain HTTP server without authentication.
+func LoadWebConfig(path string) (*WebConfig, error) {
+	cfg := &WebConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read web config: %w", err)
+	}
+
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("parse web config %s: %w", path, err)
+	}
+
+	// Relative paths are resolved against the config directory like in the exporter-toolkit.
+	if cfg.TLSServerConfig != nil {
+		dir := filepath.Dir(path)
+		for _, file := range []*string{&cfg.TLSServerConfig.CertFile, &cfg.TLSServerConfig.KeyFile, &cfg.TLSServerConfig.ClientCAFile} {
+			if *file != "" && !filepath.IsAbs(*file) {
+				*file = filepath.Join(dir, *file)
+			}
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Validate checks that the web config is complete.
+func (c *WebConfig) Validate() error {
+	if tlsConfig := c.TLSServerConfig; tlsConfig != nil {
+		if tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" {
+			return errors.New("both cert_file and key_file are required for TLS")
+		}
+		clientAuth, ok := clientAuthTypes[tlsConfig.ClientAuthType]
+		if tlsConfig.ClientAuthType != "" && !ok {
+			return fmt.Errorf("unknown client_auth_type %q", tlsConfig.ClientAuthType)
+		}
+		verifiesClients := clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert
+		if verifiesClients && tlsConfig.ClientCAFile == "" {
+			return fmt.Errorf("client_ca_file is required for client_auth_type %q", tlsConfig.ClientAuthType)
+		}
+		if _, ok := tlsVersions[tlsConfig.MinVersion]; tlsConfig.MinVersion != "" && !ok {
+			return fmt.Errorf("unknown min_version %q", tlsConfig.MinVersion)
+		}
+	}
+
+	if len(c.BasicAuthUsers) > 0 && c.KubernetesAuth != nil {
+		return errors.New("basic_auth_users and kubernetes_auth cannot be used together")
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   WebConfig
+		valid bool
+	}{
+		{name: "empty", cfg: WebConfig{}, valid: true},
+		{name: "tls", cfg: WebConfig{TLSServerConfig: &TLSServerConfig{CertFile: "tls.crt", KeyFile: "tls.key"}}, valid: true},
+		{name: "no key", cfg: WebConfig{TLSServerConfig: &TLSServerConfig{CertFile: "tls.crt"}}},
+		{
+			name: "unknown client auth type",
+			cfg:  WebConfig{TLSServerConfig: &TLSServerConfig{CertFile: "tls.crt", KeyFile: "tls.key", ClientAuthType: "Always"}},
+		},
+		{
+			name: "verify without CA",
+			cfg:  WebConfig{TLSServerConfig: &TLSServerConfig{CertFile: "tls.crt", KeyFile: "tls.key", ClientAuthType: "RequireAndVerifyClientCert"}},
+		},
+		{
+			name: "unknown min version",
+			cfg:  WebConfig{TLSServerConfig: &TLSServerConfig{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "SSL3"}},
+		},
+		{
+			name: "both auth methods",
+			cfg:  WebConfig{BasicAuthUsers: map[string]string{"user": "hash"}, KubernetesAuth: &KubernetesAuthConfig{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "web.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+tls_server_config:
+  cert_file: tls.crt
+  key_file: /etc/tls/tls.key
+kubernetes_auth:
+  cache_ttl: 30s
+`), 0o600))
+
+	cfg, err := LoadWebConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "tls.crt"), cfg.TLSServerConfig.CertFile)
+	require.Equal(t, "/etc/tls/tls.key", cfg.TLSServerConfig.KeyFile)
+	require.Equal(t, 30*time.Second, cfg.KubernetesAuth.CacheTTL)
+
+	require.NoError(t, os.WriteFile(path, []byte("basic_auth: {}"), 0o600))
+	_, err = LoadWebConfig(path)
+	require.Error(t, err)
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	config := &TLSServerConfig{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")}
+
+	writeCert(t, config, "first")
+	reloader, err := newCertReloader(config)
+	require.NoError(t, err)
+
+	require.Equal(t, "first", servedCommonName(t, reloader))
+
+	// Rotated files are picked up on the next handshake.
+	writeCert(t, config, "second")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(config.CertFile, future, future))
+	require.Equal(t, "second", servedCommonName(t, reloader))
+
+	// Broken files keep the previous certificate.
+	require.NoError(t, os.WriteFile(config.KeyFile, []byte("broken"), 0o600))
+	future = future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(config.KeyFile, future, future))
+	require.Equal(t, "second", servedCommonName(t, reloader))
+}
+
+func writeCert(t *testing.T, config *TLSServerConfig, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(config.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(config.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func servedCommonName(t *testing.T, reloader *certReloader) string {
+	tlsConfig, err := reloader.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.Len(t, tlsConfig.Certificates, 1)
+
+	cert, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	return cert.Subject.CommonName
+}
+
+func TestMetricsServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverConfig := &TLSServerConfig{
+		CertFile:     filepath.Join(dir, "tls.crt"),
+		KeyFile:      filepath.Join(dir, "tls.key"),
+		ClientCAFile: filepath.Join(dir, "client.crt"),
+	}
+	writeCert(t, serverConfig, "server")
+	// The self-signed client certificate is its own CA.
+	clientConfig := &TLSServerConfig{CertFile: serverConfig.ClientCAFile, KeyFile: filepath.Join(dir, "client.key")}
+	writeCert(t, clientConfig, "client")
+
+	m, err := NewMetricsServer(nil, &WebConfig{TLSServerConfig: serverConfig}, nil)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- m.Serve(listener) }()
+	url := "https://" + listener.Addr().String() + "/healthz"
+
+	clientCert, err := tls.LoadX509KeyPair(clientConfig.CertFile, clientConfig.KeyFile)
+	require.NoError(t, err)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			// The server certificate has no SANs, its common name is checked instead.
+			InsecureSkipVerify: true,
+			Certificates:       certs,
+		}}}
+	}
+
+	resp, err := client(clientCert).Get(url)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "server", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	require.NoError(t, resp.Body.Close())
+
+	// Clients without certificates are rejected by mTLS.
+	_, err = client().Get(url)
+	require.Error(t, err)
+
+	m.Close()
+	require.ErrorIs(t, <-serveErr, http.ErrServerClosed)
+}
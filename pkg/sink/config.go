@@ -0,0 +1,109 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	StdoutType = "stdout"
+	FileType   = "file"
+	LokiType   = "loki"
+)
+
+// Config describes a single sink.
+type Config struct {
+	Name   string      `yaml:"name"`
+	Type   string      `yaml:"type"`
+	Filter Filter      `yaml:"filter,omitempty"`
+	Batch  BatchConfig `yaml:"batch,omitempty"`
+
+	File *FileConfig `yaml:"file,omitempty"`
+	Loki *LokiConfig `yaml:"loki,omitempty"`
+}
+
+// BatchConfig controls how entries are grouped before writing to a sink.
+type BatchConfig struct {
+	// Size is the max number of entries in a batch.
+	Size int `yaml:"size,omitempty"`
+	// Interval is the max time an entry waits in the batch.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Capacity is the max number of entries waiting for a batch. New entries are dropped on overflow.
+	Capacity int `yaml:"capacity,omitempty"`
+}
+
+// DefaultConfig is applied to every sink section before unmarshalling.
+var DefaultConfig = Config{
+	Batch: BatchConfig{
+		Size:     100,
+		Interval: 5 * time.Second,
+		Capacity: 10000,
+	},
+}
+
+// UnmarshalYAML sets defaults for omitted fields.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate checks that the config is complete.
+func (c *Config) Validate() error {
+	if c.Name == "" {
+		return errors.New("sink name is required")
+	}
+	if c.Batch.Size <= 0 || c.Batch.Interval <= 0 || c.Batch.Capacity <= 0 {
+		return fmt.Errorf("sink %q: batch size, interval and capacity must be positive", c.Name)
+	}
+
+	switch c.Type {
+	case StdoutType:
+	case FileType:
+		if c.File == nil || c.File.Path == "" {
+			return fmt.Errorf("sink %q: file path is required", c.Name)
+		}
+	case LokiType:
+		if c.Loki == nil || c.Loki.URL == "" {
+			return fmt.Errorf("sink %q: loki url is required", c.Name)
+		}
+	default:
+		return fmt.Errorf("sink %q: unknown type %q", c.Name, c.Type)
+	}
+	return nil
+}
+
+// New creates the sink described by the config.
+func New(c Config) (Sink, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch c.Type {
+	case StdoutType:
+		return NewWriterSink(os.Stdout), nil
+	case FileType:
+		return NewFileSink(*c.File)
+	case LokiType:
+		return NewLokiSink(*c.Loki)
+	}
+	return nil, fmt.Errorf("unknown sink type %q", c.Type)
+}
@@ -0,0 +1,229 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
+)
+
+var (
+	writtenEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_exporter_sink_entries_total",
+		Help: "Events successfully written to the sink",
+	}, []string{"sink"})
+	failedEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_exporter_sink_failed_entries_total",
+		Help: "Events failed to be written to the sink",
+	}, []string{"sink"})
+	droppedEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_exporter_sink_dropped_entries_total",
+		Help: "Events dropped because of the sink queue overflow",
+	}, []string{"sink"})
+)
+
+// MustRegisterMetrics registers metrics of sinks in the registry.
+func MustRegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(writtenEntries, failedEntries, droppedEntries)
+}
+
+// Dispatcher fans events out to sinks. Every event is sent only if it was changed since the last time,
+// so informer resyncs and relists do not duplicate entries.
+type Dispatcher struct {
+	mu   sync.Mutex
+	seen map[types.UID]seenEvent
+	// seenTTL is for how long to remember delivered events.
+	seenTTL time.Duration
+
+	sinks []*batchingSink
+	now   func() time.Time
+}
+
+type seenEvent struct {
+	resourceVersion string
+	count           int32
+	lastSeen        time.Time
+}
+
+type batchingSink struct {
+	name   string
+	sink   Sink
+	filter Filter
+	batch  BatchConfig
+
+	queue chan Entry
+}
+
+// NewDispatcher creates sinks from configs. Delivered events are remembered for the seenTTL to deduplicate them.
+func NewDispatcher(configs []Config, seenTTL time.Duration) (*Dispatcher, error) {
+	if seenTTL <= 0 {
+		seenTTL = time.Hour
+	}
+	d := &Dispatcher{seen: make(map[types.UID]seenEvent), seenTTL: seenTTL, now: time.Now}
+
+	names := make(map[string]struct{}, len(configs))
+	for _, c := range configs {
+		if _, ok := names[c.Name]; ok {
+			d.Close()
+			return nil, fmt.Errorf("duplicated sink %q", c.Name)
+		}
+		names[c.Name] = struct{}{}
+
+		s, err := New(c)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.AddSink(c.Name, s, c.Filter, c.Batch)
+	}
+	return d, nil
+}
+
+// AddSink adds the sink with the filter and batching options. It must be called before Run.
+func (d *Dispatcher) AddSink(name string, s Sink, filter Filter, batch BatchConfig) {
+	d.sinks = append(d.sinks, &batchingSink{
+		name:   name,
+		sink:   s,
+		filter: filter,
+		batch:  batch,
+		queue:  make(chan Entry, batch.Capacity),
+	})
+}
+
+// Handle sends the event to all sinks if it was changed. Entries are dropped if a sink cannot keep up.
+func (d *Dispatcher) Handle(event *v1.Event) {
+	if len(d.sinks) == 0 || !d.changed(event) {
+		return
+	}
+
+	entry := EventToEntry(event)
+	for _, s := range d.sinks {
+		if !s.filter.Match(&entry) {
+			continue
+		}
+
+		select {
+		case s.queue <- entry:
+		default:
+			droppedEntries.WithLabelValues(s.name).Inc()
+		}
+	}
+}
+
+func (d *Dispatcher) changed(event *v1.Event) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	previous, ok := d.seen[event.UID]
+	d.seen[event.UID] = seenEvent{resourceVersion: event.ResourceVersion, count: event.Count, lastSeen: d.now()}
+
+	return !ok || previous.resourceVersion != event.ResourceVersion || previous.count != event.Count
+}
+
+// Run writes batches to sinks until the stop channel is closed. Queued entries are flushed on exit.
+func (d *Dispatcher) Run(stopCh <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, s := range d.sinks {
+		wg.Add(1)
+		go func(s *batchingSink) {
+			defer wg.Done()
+			s.run(stopCh)
+		}(s)
+	}
+
+	ticker := time.NewTicker(d.seenTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.forgetStale()
+		case <-stopCh:
+			wg.Wait()
+			d.Close()
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) forgetStale() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	deadline := d.now().Add(-d.seenTTL)
+	for uid, seen := range d.seen {
+		if seen.lastSeen.Before(deadline) {
+			delete(d.seen, uid)
+		}
+	}
+}
+
+// Close closes all sinks.
+func (d *Dispatcher) Close() {
+	for _, s := range d.sinks {
+		if err := s.sink.Close(); err != nil {
+			logging.Logger("sink").Error(err, "close sink", "sink", s.name)
+		}
+	}
+}
+
+func (s *batchingSink) run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(s.batch.Interval)
+	defer ticker.Stop()
+
+	batch := make([]Entry, 0, s.batch.Size)
+	for {
+		select {
+		case entry := <-s.queue:
+			batch = append(batch, entry)
+			if len(batch) >= s.batch.Size {
+				batch = s.write(batch)
+			}
+		case <-ticker.C:
+			batch = s.write(batch)
+		case <-stopCh:
+			for {
+				select {
+				case entry := <-s.queue:
+					batch = append(batch, entry)
+				default:
+					s.write(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// write sends the batch to the sink and returns the emptied batch for reuse.
+func (s *batchingSink) write(batch []Entry) []Entry {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := s.sink.Write(batch); err != nil {
+		failedEntries.WithLabelValues(s.name).Add(float64(len(batch)))
+		logging.Logger("sink").Error(err, "write to sink", "sink", s.name, "entries", len(batch))
+	} else {
+		writtenEntries.WithLabelValues(s.name).Add(float64(len(batch)))
+	}
+	return batch[:0]
+}
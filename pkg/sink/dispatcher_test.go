@@ -0,0 +1,128 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	batches [][]Entry
+	closed  bool
+}
+
+func (s *recordingSink) Write(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]Entry(nil), entries...))
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingSink) reasons() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([][]string, 0, len(s.batches))
+	for _, batch := range s.batches {
+		reasons := make([]string, 0, len(batch))
+		for _, entry := range batch {
+			reasons = append(reasons, entry.Reason)
+		}
+		result = append(result, reasons)
+	}
+	return result
+}
+
+func testEvent(uid, resourceVersion, eventType, reason string) *v1.Event {
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), ResourceVersion: resourceVersion, Namespace: "default"},
+		Type:       eventType,
+		Reason:     reason,
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	all := &recordingSink{}
+	warnings := &recordingSink{}
+
+	dispatcher, err := NewDispatcher(nil, time.Hour)
+	require.NoError(t, err)
+
+	dispatcher.AddSink("all", all, Filter{}, BatchConfig{Size: 2, Interval: time.Hour, Capacity: 10})
+	dispatcher.AddSink("warnings", warnings, Filter{Types: []string{"Warning"}}, BatchConfig{Size: 10, Interval: time.Hour, Capacity: 10})
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(stopCh)
+		close(done)
+	}()
+
+	dispatcher.Handle(testEvent("1", "1", "Normal", "Started"))
+	// Resync of the same event must not produce a duplicate.
+	dispatcher.Handle(testEvent("1", "1", "Normal", "Started"))
+	dispatcher.Handle(testEvent("2", "2", "Warning", "BackOff"))
+	dispatcher.Handle(testEvent("2", "3", "Warning", "BackOff"))
+
+	// The first batch of the "all" sink is full and must be written without waiting for the interval.
+	require.Eventually(t, func() bool {
+		return len(all.reasons()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	close(stopCh)
+	<-done
+
+	require.Equal(t, [][]string{{"Started", "BackOff"}, {"BackOff"}}, all.reasons())
+	require.Equal(t, [][]string{{"BackOff", "BackOff"}}, warnings.reasons())
+	require.True(t, all.closed)
+	require.True(t, warnings.closed)
+}
+
+func TestDispatcherForgetStale(t *testing.T) {
+	dispatcher, err := NewDispatcher(nil, time.Hour)
+	require.NoError(t, err)
+
+	curTime := time.Now()
+	dispatcher.now = func() time.Time { return curTime }
+	require.True(t, dispatcher.changed(testEvent("1", "1", "Normal", "Started")))
+	require.False(t, dispatcher.changed(testEvent("1", "1", "Normal", "Started")))
+
+	dispatcher.now = func() time.Time { return curTime.Add(2 * time.Hour) }
+	dispatcher.forgetStale()
+	require.True(t, dispatcher.changed(testEvent("1", "1", "Normal", "Started")))
+}
+
+func TestDispatcherDuplicatedSinks(t *testing.T) {
+	configs := []Config{DefaultConfig, DefaultConfig}
+	configs[0].Name, configs[0].Type = "stdout", StdoutType
+	configs[1].Name, configs[1].Type = "stdout", StdoutType
+
+	_, err := NewDispatcher(configs, time.Hour)
+	require.EqualError(t, err, `duplicated sink "stdout"`)
+}
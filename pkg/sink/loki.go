@@ -0,0 +1,153 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const lokiPushPath = "/loki/api/v1/push"
+
+var _ Sink = (*LokiSink)(nil)
+
+// LokiConfig describes the Loki push API client.
+type LokiConfig struct {
+	// URL is the Loki address, the push API path is appended if missing.
+	URL      string `yaml:"url"`
+	TenantID string `yaml:"tenant_id,omitempty"`
+	// Labels are static stream labels. Events are additionally split to streams by namespace and type.
+	Labels   map[string]string `yaml:"labels,omitempty"`
+	Username string            `yaml:"username,omitempty"`
+	Password string            `yaml:"password,omitempty"`
+	Timeout  time.Duration     `yaml:"timeout,omitempty"`
+}
+
+// LokiSink pushes entries to Loki using the JSON push API.
+type LokiSink struct {
+	config LokiConfig
+	url    string
+	client *http.Client
+}
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// NewLokiSink returns the Loki push API client.
+func NewLokiSink(config LokiConfig) (*LokiSink, error) {
+	url := strings.TrimSuffix(config.URL, "/")
+	if !strings.HasSuffix(url, lokiPushPath) {
+		url += lokiPushPath
+	}
+
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &LokiSink{config: config, url: url, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (s *LokiSink) Write(entries []Entry) error {
+	body, err := json.Marshal(s.pushRequest(entries))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.config.TenantID)
+	}
+	if s.config.Username != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("loki returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// pushRequest groups entries to streams by namespace and type to keep Loki labels cardinality low. Values of
+// streams are ordered by time because Loki rejects out-of-order entries.
+func (s *LokiSink) pushRequest(entries []Entry) lokiPushRequest {
+	streams := make(map[string]*lokiStream)
+	keys := make([]string, 0)
+
+	entries = append([]Entry(nil), entries...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp.Before(entries[j].Timestamp) })
+
+	for i := range entries {
+		entry := &entries[i]
+		key := entry.Namespace + "/" + entry.Type
+
+		stream, ok := streams[key]
+		if !ok {
+			labels := make(map[string]string, len(s.config.Labels)+2)
+			for k, v := range s.config.Labels {
+				labels[k] = v
+			}
+			labels["namespace"] = entry.Namespace
+			labels["type"] = entry.Type
+
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			keys = append(keys, key)
+		}
+
+		line, _ := json.Marshal(entry)
+		timestamp := strconv.FormatInt(entry.Timestamp.UnixNano(), 10)
+		stream.Values = append(stream.Values, [2]string{timestamp, string(line)})
+	}
+
+	sort.Strings(keys)
+	request := lokiPushRequest{Streams: make([]lokiStream, 0, len(keys))}
+	for _, key := range keys {
+		request.Streams = append(request.Streams, *streams[key])
+	}
+	return request
+}
+
+func (s *LokiSink) Close() error {
+	return nil
+}
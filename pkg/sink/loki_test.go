@@ -0,0 +1,83 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLokiSink(t *testing.T) {
+	var (
+		request lokiPushRequest
+		tenant  string
+		path    string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		tenant = r.Header.Get("X-Scope-OrgID")
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s, err := NewLokiSink(LokiConfig{URL: srv.URL, TenantID: "tenant", Labels: map[string]string{"job": "events"}})
+	require.NoError(t, err)
+
+	timestamp := time.Unix(1600000000, 0)
+	err = s.Write([]Entry{
+		{Timestamp: timestamp, Namespace: "default", Type: "Normal", Reason: "Started"},
+		{Timestamp: timestamp, Namespace: "default", Type: "Warning", Reason: "BackOff"},
+		{Timestamp: timestamp.Add(-time.Second), Namespace: "default", Type: "Normal", Reason: "Pulled"},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, lokiPushPath, path)
+	require.Equal(t, "tenant", tenant)
+	require.Len(t, request.Streams, 2)
+
+	require.Equal(t, map[string]string{"job": "events", "namespace": "default", "type": "Normal"}, request.Streams[0].Stream)
+	require.Len(t, request.Streams[0].Values, 2)
+	require.Equal(t, "1599999999000000000", request.Streams[0].Values[0][0])
+	require.Equal(t, "1600000000000000000", request.Streams[0].Values[1][0])
+
+	var entry Entry
+	require.NoError(t, json.Unmarshal([]byte(request.Streams[0].Values[0][1]), &entry))
+	require.Equal(t, "Pulled", entry.Reason)
+
+	require.NoError(t, json.Unmarshal([]byte(request.Streams[1].Values[0][1]), &entry))
+	require.Equal(t, "BackOff", entry.Reason)
+}
+
+func TestLokiSinkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "entry too far behind", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	s, err := NewLokiSink(LokiConfig{URL: srv.URL})
+	require.NoError(t, err)
+
+	err = s.Write([]Entry{{Timestamp: time.Now()}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "entry too far behind")
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Sink ships raw events somewhere outside the exporter, e.g., to a log storage.
+type Sink interface {
+	// Write delivers the batch of entries. Entries of a failed batch are not retried.
+	Write(entries []Entry) error
+	Close() error
+}
+
+// Entry is the structured representation of a Kubernetes event with the full message.
+type Entry struct {
+	Timestamp       time.Time `json:"timestamp"`
+	FirstTimestamp  time.Time `json:"first_timestamp"`
+	UID             string    `json:"uid"`
+	ResourceVersion string    `json:"resource_version"`
+	Namespace       string    `json:"namespace"`
+	Name            string    `json:"name"`
+	Type            string    `json:"type"`
+	Reason          string    `json:"reason"`
+	Message         string    `json:"message"`
+	Count           int32     `json:"count"`
+
+	SourceComponent     string `json:"source_component,omitempty"`
+	SourceHost          string `json:"source_host,omitempty"`
+	ReportingController string `json:"reporting_controller,omitempty"`
+	ReportingInstance   string `json:"reporting_instance,omitempty"`
+
+	InvolvedKind      string `json:"involved_kind"`
+	InvolvedName      string `json:"involved_name"`
+	InvolvedNamespace string `json:"involved_namespace,omitempty"`
+	InvolvedUID       string `json:"involved_uid,omitempty"`
+}
+
+// EventToEntry converts Kubernetes core v1.Event to the sink entry.
+func EventToEntry(event *v1.Event) Entry {
+	timestamp := event.LastTimestamp.Time
+	if timestamp.IsZero() {
+		timestamp = event.EventTime.Time
+	}
+	if timestamp.IsZero() {
+		timestamp = event.CreationTimestamp.Time
+	}
+
+	return Entry{
+		Timestamp:       timestamp,
+		FirstTimestamp:  event.FirstTimestamp.Time,
+		UID:             string(event.UID),
+		ResourceVersion: event.ResourceVersion,
+		Namespace:       event.Namespace,
+		Name:            event.Name,
+		Type:            event.Type,
+		Reason:          event.Reason,
+		Message:         event.Message,
+		Count:           event.Count,
+
+		SourceComponent:     event.Source.Component,
+		SourceHost:          event.Source.Host,
+		ReportingController: event.ReportingController,
+		ReportingInstance:   event.ReportingInstance,
+
+		InvolvedKind:      event.InvolvedObject.Kind,
+		InvolvedName:      event.InvolvedObject.Name,
+		InvolvedNamespace: event.InvolvedObject.Namespace,
+		InvolvedUID:       string(event.InvolvedObject.UID),
+	}
+}
+
+// Filter selects entries for a sink. Empty lists match everything.
+type Filter struct {
+	Namespaces    []string `yaml:"namespaces,omitempty"`
+	Types         []string `yaml:"types,omitempty"`
+	Reasons       []string `yaml:"reasons,omitempty"`
+	InvolvedKinds []string `yaml:"involved_kinds,omitempty"`
+}
+
+// Match returns true if the entry passes the filter.
+func (f *Filter) Match(entry *Entry) bool {
+	return matchAny(f.Namespaces, entry.Namespace) &&
+		matchAny(f.Types, entry.Type) &&
+		matchAny(f.Reasons, entry.Reason) &&
+		matchAny(f.InvolvedKinds, entry.InvolvedKind)
+}
+
+func matchAny(allowed []string, value string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == value {
+			return true
+		}
+	}
+	return false
+}
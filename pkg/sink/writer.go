@@ -0,0 +1,154 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var (
+	_ Sink = (*WriterSink)(nil)
+	_ Sink = (*FileSink)(nil)
+)
+
+// WriterSink writes entries as JSON lines, e.g., to stdout.
+type WriterSink struct {
+	w io.Writer
+}
+
+// NewWriterSink returns the JSON lines sink for the writer.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(entries []Entry) error {
+	// The whole batch goes with a single write call to not interleave with other writers.
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for i := range entries {
+		if err := encoder.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+	_, err := s.w.Write(buf.Bytes())
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// FileConfig describes the rotating file sink.
+type FileConfig struct {
+	Path string `yaml:"path"`
+	// MaxSizeBytes is the size after which the file is rotated. Zero means no rotation.
+	MaxSizeBytes int64 `yaml:"max_size_bytes,omitempty"`
+	// MaxBackups is the number of rotated files to keep.
+	MaxBackups int `yaml:"max_backups,omitempty"`
+}
+
+// FileSink writes entries as JSON lines to the file and rotates it by size.
+// Rotated files get numeric suffixes, the lower suffix the newer the file.
+type FileSink struct {
+	config FileConfig
+
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens or creates the file for appending entries.
+func NewFileSink(config FileConfig) (*FileSink, error) {
+	s := &FileSink{config: config}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.config.Path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(entries []Entry) error {
+	for i := range entries {
+		line, err := json.Marshal(&entries[i])
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+
+		if s.config.MaxSizeBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.config.MaxSizeBytes {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+
+		n, err := s.file.Write(line)
+		s.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	if s.config.MaxBackups > 0 {
+		_ = os.Remove(s.backupPath(s.config.MaxBackups))
+		for i := s.config.MaxBackups - 1; i > 0; i-- {
+			_ = os.Rename(s.backupPath(i), s.backupPath(i+1))
+		}
+		if err := os.Rename(s.config.Path, s.backupPath(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.config.Path); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+func (s *FileSink) backupPath(index int) string {
+	return s.config.Path + "." + strconv.Itoa(index)
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewWriterSink(&buf)
+
+	require.NoError(t, s.Write([]Entry{{Reason: "Started"}, {Reason: "Killing"}}))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var entry Entry
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	require.Equal(t, "Killing", entry.Reason)
+}
+
+// countingWriter counts write calls.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestWriterSinkWritesBatchAtOnce(t *testing.T) {
+	var w countingWriter
+	s := NewWriterSink(&w)
+
+	// The batch is bigger than default buffers of writers.
+	entries := make([]Entry, 1000)
+	for i := range entries {
+		entries[i] = Entry{Reason: "Started", Message: strings.Repeat("x", 100)}
+	}
+	require.NoError(t, s.Write(entries))
+
+	require.Equal(t, 1, w.writes)
+	require.Equal(t, len(entries), strings.Count(w.String(), "\n"))
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events", "events.log")
+
+	line, err := json.Marshal(Entry{Reason: "Started"})
+	require.NoError(t, err)
+
+	// Every file fits two entries.
+	s, err := NewFileSink(FileConfig{Path: path, MaxSizeBytes: int64(2 * (len(line) + 1)), MaxBackups: 2})
+	require.NoError(t, err)
+
+	for i := 0; i < 7; i++ {
+		require.NoError(t, s.Write([]Entry{{Reason: "Started"}}))
+	}
+	require.NoError(t, s.Close())
+
+	for file, lines := range map[string]int{path: 1, path + ".1": 2, path + ".2": 2} {
+		content, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.Equal(t, lines, strings.Count(string(content), "\n"), file)
+	}
+
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+func TestStores(t *testing.T) {
+	stores := map[string]Store{
+		"file":      NewFileStore(filepath.Join(t.TempDir(), "vault.snapshot")),
+		"configmap": NewConfigMapStore(fake.NewSimpleClientset(), "monitoring", "events-exporter-snapshot"),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Load(ctx)
+			require.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, store.Save(ctx, []byte("first")))
+			require.NoError(t, store.Save(ctx, []byte("second")))
+
+			snapshot, err := store.Load(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []byte("second"), snapshot)
+		})
+	}
+}
+
+func TestSnapshotter(t *testing.T) {
+	mappings := []vault.Mapping{{Name: "test_metric", LabelNames: []string{"name"}, TTL: time.Hour}}
+	store := NewFileStore(filepath.Join(t.TempDir(), "vault.snapshot"))
+
+	v := vault.NewVault()
+	require.NoError(t, v.RegisterMappings(mappings))
+
+	// Nothing to restore on the first start.
+	require.NoError(t, NewSnapshotter(v, store, time.Hour).Restore(context.Background()))
+
+	require.NoError(t, v.Store("test_metric", vault.Sample{ID: "uid-1", Labels: prometheus.Labels{"name": "test"}, Value: 1}))
+
+	// The last snapshot is saved on stop.
+	stopCh := make(chan struct{})
+	close(stopCh)
+	NewSnapshotter(v, store, time.Hour).Run(stopCh)
+
+	restarted := vault.NewVault()
+	require.NoError(t, restarted.RegisterMappings(mappings))
+	require.NoError(t, NewSnapshotter(restarted, store, time.Hour).Restore(context.Background()))
+
+	samples := restarted.Samples()
+	require.Len(t, samples, 1)
+	require.Equal(t, "uid-1", samples[0].ID)
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
+)
+
+// saveTimeout limits a single save, so shutdown is not blocked by an unavailable store.
+const saveTimeout = 30 * time.Second
+
+// Vault is the state to snapshot.
+type Vault interface {
+	Save(w io.Writer) error
+	Restore(r io.Reader) (int, error)
+}
+
+// Snapshotter periodically saves the vault state to the store, so it survives restarts.
+type Snapshotter struct {
+	vault    Vault
+	store    Store
+	interval time.Duration
+}
+
+// NewSnapshotter returns the snapshotter saving the vault state every interval.
+func NewSnapshotter(vault Vault, store Store, interval time.Duration) *Snapshotter {
+	return &Snapshotter{vault: vault, store: store, interval: interval}
+}
+
+// Restore loads the latest snapshot into the vault. A missing snapshot is not an error.
+func (s *Snapshotter) Restore(ctx context.Context) error {
+	snapshot, err := s.store.Load(ctx)
+	if errors.Is(err, ErrNotFound) {
+		logging.Logger("snapshot").Info("no vault snapshot found, starting from scratch")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load snapshot: %w", err)
+	}
+
+	restored, err := s.vault.Restore(bytes.NewReader(snapshot))
+	if err != nil {
+		return fmt.Errorf("restore snapshot: %w", err)
+	}
+	logging.Logger("snapshot").Info("restored metrics from the vault snapshot", "metrics", restored)
+	return nil
+}
+
+// Save writes the current vault state to the store.
+func (s *Snapshotter) Save(ctx context.Context) error {
+	var snapshot bytes.Buffer
+	if err := s.vault.Save(&snapshot); err != nil {
+		return err
+	}
+	if err := s.store.Save(ctx, snapshot.Bytes()); err != nil {
+		return fmt.Errorf("save snapshot: %w", err)
+	}
+	return nil
+}
+
+// Run saves snapshots periodically and the last one once the stop channel is closed.
+func (s *Snapshotter) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.save()
+		case <-stopCh:
+			s.save()
+			return
+		}
+	}
+}
+
+func (s *Snapshotter) save() {
+	ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+	defer cancel()
+
+	if err := s.Save(ctx); err != nil {
+		logging.Logger("snapshot").Error(err, "save vault snapshot")
+	}
+}
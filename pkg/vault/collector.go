@@ -15,21 +15,38 @@ package vault
 
 import (
 	"bytes"
+	"fmt"
 	"hash/fnv"
+	"sort"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/nabokihms/events_exporter/pkg/logging"
 )
 
-const labelsSeparator = byte(255)
+const (
+	labelsSeparator = byte(255)
+	// storeLockShards is the number of locks serializing updates of metrics with the same labels hash.
+	storeLockShards = 256
+	// exemplarMaxRunes is the max total number of runes in exemplar labels according to the OpenMetrics spec.
+	exemplarMaxRunes = 128
+)
 
 type ConstMetricCollector interface {
 	Describe(chan<- *prometheus.Desc)
 	Collect(chan<- prometheus.Metric)
-	Store(time.Time, Sample)
-	Clear(time.Time)
+	Store(time.Time, Sample) (StampedGaugeMetric, error)
+	Clear(time.Time) int
+	Snapshot() []StampedGaugeMetric
+	Restore(time.Time, []StampedGaugeMetric) int
+	Len() int
+	Drop() error
 }
 
 var (
@@ -40,50 +57,152 @@ type StampedGaugeMetric struct {
 	Value float64
 
 	LabelValues []string
+	FirstSeen   time.Time
 	LastUpdate  time.Time
+	// TTL is the effective time to live of the metric after its last update.
+	TTL time.Duration
+
+	Exemplar prometheus.Labels
+	// ID is the id of the last stored sample, e.g., the source event uid.
+	ID string
+}
+
+// ExpiresAt returns when the metric is removed unless it is updated.
+func (m StampedGaugeMetric) ExpiresAt() time.Time {
+	return m.LastUpdate.Add(m.TTL)
 }
 
+// GaugeCollector keeps metrics of a single mapping. Collect never holds collector locks, and the storage
+// is only locked shard by shard while metrics are copied, so Store does not wait for a scrape to finish.
 type GaugeCollector struct {
-	mu sync.RWMutex
+	// storeLocks serialize read-modify-write updates of metrics by their labels hash.
+	storeLocks [storeLockShards]sync.Mutex
 
-	collection map[uint64]StampedGaugeMetric
-	desc       *prometheus.Desc
-	mapping    Mapping
+	storage   Storage
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	mapping   Mapping
+	ttlRules  []ttlRule
+
+	firstTimestampDesc *prometheus.Desc
+	lastTimestampDesc  *prometheus.Desc
 }
 
+// NewConstGaugeCollector returns the collector keeping metrics in memory.
 func NewConstGaugeCollector(mapping Mapping) *GaugeCollector {
+	return NewGaugeCollectorWithStorage(mapping, NewMemoryStorage())
+}
+
+// NewGaugeCollectorWithStorage returns the collector keeping metrics in the storage.
+func NewGaugeCollectorWithStorage(mapping Mapping, storage Storage) *GaugeCollector {
 	desc := prometheus.NewDesc(mapping.Name, mapping.Help, mapping.LabelNames, nil)
-	return &GaugeCollector{mapping: mapping, collection: make(map[uint64]StampedGaugeMetric), desc: desc}
+	valueType, _ := mapping.valueType()
+	ttlRules, _ := compileTTLRules(mapping)
+	collector := &GaugeCollector{
+		mapping:   mapping,
+		storage:   storage,
+		desc:      desc,
+		valueType: valueType,
+		ttlRules:  ttlRules,
+	}
+
+	if mapping.FirstTimestampName != "" {
+		help := fmt.Sprintf("Unix time when %s sample was first seen", mapping.Name)
+		collector.firstTimestampDesc = prometheus.NewDesc(mapping.FirstTimestampName, help, mapping.LabelNames, nil)
+	}
+	if mapping.LastTimestampName != "" {
+		help := fmt.Sprintf("Unix time when %s sample was last updated", mapping.Name)
+		collector.lastTimestampDesc = prometheus.NewDesc(mapping.LastTimestampName, help, mapping.LabelNames, nil)
+	}
+	return collector
 }
 
 func (c *GaugeCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.desc
+	if c.firstTimestampDesc != nil {
+		ch <- c.firstTimestampDesc
+	}
+	if c.lastTimestampDesc != nil {
+		ch <- c.lastTimestampDesc
+	}
 }
 
 func (c *GaugeCollector) Collect(ch chan<- prometheus.Metric) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	err := c.storage.Range(func(_ uint64, s StampedGaugeMetric) bool {
+		c.collect(ch, s)
+		return true
+	})
+	if err != nil {
+		logging.Logger("vault").Error(err, "collect metrics", "metric", c.mapping.Name)
+	}
+}
+
+func (c *GaugeCollector) collect(ch chan<- prometheus.Metric, s StampedGaugeMetric) {
+	metric, err := prometheus.NewConstMetric(c.desc, c.valueType, s.Value, s.LabelValues...)
+	if err != nil {
+		// TODO(nabokihms): add counter for errors
+		logging.Logger("vault").Error(err, "prepare gauge", "metric", c.mapping.Name)
+		return
+	}
+
+	if c.mapping.ExplicitTimestamps {
+		metric = prometheus.NewMetricWithTimestamp(s.LastUpdate, metric)
+	}
 
-	for _, s := range c.collection {
-		metric, err := prometheus.NewConstMetric(c.desc, prometheus.GaugeValue, s.Value, s.LabelValues...)
+	if c.valueType == prometheus.CounterValue && len(s.Exemplar) > 0 {
+		exemplarMetric, err := withExemplar(metric, s)
 		if err != nil {
-			// TODO(nabokihms): add counter for errors
-			log.Warnf("prepare gauge: %v", err)
-			continue
+			logging.Logger("vault").Error(err, "prepare exemplar", "metric", c.mapping.Name)
+		} else {
+			metric = exemplarMetric
 		}
-		ch <- metric
 	}
+	ch <- metric
+
+	c.collectTimestamp(ch, c.firstTimestampDesc, s.FirstSeen, s.LabelValues)
+	c.collectTimestamp(ch, c.lastTimestampDesc, s.LastUpdate, s.LabelValues)
+}
+
+func (c *GaugeCollector) collectTimestamp(ch chan<- prometheus.Metric, desc *prometheus.Desc, timestamp time.Time, labelValues []string) {
+	if desc == nil {
+		return
+	}
+
+	value := float64(timestamp.UnixNano()) / float64(time.Second)
+	metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
+	if err != nil {
+		logging.Logger("vault").Error(err, "prepare timestamp gauge", "metric", c.mapping.Name)
+		return
+	}
+	ch <- metric
 }
 
-func (c *GaugeCollector) Store(timestamp time.Time, sample Sample) {
-	labelsHash := hashLabels(sample.Labels)
+// Store saves the sample and returns the stored metric.
+func (c *GaugeCollector) Store(timestamp time.Time, sample Sample) (StampedGaugeMetric, error) {
+	labelValues, err := c.labelValues(sample.Labels)
+	if err != nil {
+		return StampedGaugeMetric{}, err
+	}
+	labelsHash := hashLabels(labelValues)
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	lock := c.storeLock(labelsHash)
+	lock.Lock()
+	defer lock.Unlock()
 
-	storedMetric, ok := c.collection[labelsHash]
+	storedMetric, ok, err := c.storage.Get(labelsHash)
+	if err != nil {
+		return StampedGaugeMetric{}, err
+	}
 	if !ok {
-		storedMetric = StampedGaugeMetric{LabelValues: sample.Labels, LastUpdate: timestamp}
+		// The limit is checked without locking the whole storage, concurrent stores may exceed it slightly.
+		if c.mapping.SeriesLimit > 0 && c.storage.Len() >= c.mapping.SeriesLimit {
+			return StampedGaugeMetric{}, fmt.Errorf("%w: %d series", ErrLimitExceeded, c.mapping.SeriesLimit)
+		}
+		storedMetric = StampedGaugeMetric{LabelValues: labelValues, FirstSeen: timestamp, LastUpdate: timestamp}
+	}
+
+	if !sample.FirstTimestamp.IsZero() {
+		storedMetric.FirstSeen = sample.FirstTimestamp
 	}
 
 	// If sample contains last update information, that means it was collected from the metric source.
@@ -97,19 +216,120 @@ func (c *GaugeCollector) Store(timestamp time.Time, sample Sample) {
 		storedMetric.LastUpdate = timestamp
 	}
 
+	// Sample cannot be updated before it was seen for the first time.
+	if storedMetric.LastUpdate.Before(storedMetric.FirstSeen) {
+		storedMetric.FirstSeen = storedMetric.LastUpdate
+	}
+
+	storedMetric.TTL = c.ttl(storedMetric.LabelValues)
 	storedMetric.Value = sample.Value
-	c.collection[labelsHash] = storedMetric
+	storedMetric.Exemplar = sample.Exemplar
+	storedMetric.ID = sample.ID
+	if err := c.storage.Put(labelsHash, storedMetric); err != nil {
+		return StampedGaugeMetric{}, err
+	}
+	return storedMetric, nil
+}
+
+// labelValues orders label values as label names of the mapping. Missing labels are empty.
+func (c *GaugeCollector) labelValues(labels prometheus.Labels) ([]string, error) {
+	values := make([]string, len(c.mapping.LabelNames))
+	found := 0
+	for i, name := range c.mapping.LabelNames {
+		if value, ok := labels[name]; ok {
+			if !utf8.ValidString(value) {
+				return nil, fmt.Errorf("%w: value of label %q", ErrInvalidUTF8, name)
+			}
+			values[i] = value
+			found++
+		}
+	}
+
+	if found != len(labels) {
+		for name := range labels {
+			if !c.hasLabel(name) {
+				return nil, fmt.Errorf("%w: label %q is not one of mapping labels %v", ErrLabelMismatch, name, c.mapping.LabelNames)
+			}
+		}
+	}
+	return values, nil
+}
+
+func (c *GaugeCollector) hasLabel(name string) bool {
+	for _, n := range c.mapping.LabelNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
 }
 
-func (c *GaugeCollector) Clear(now time.Time) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// Clear removes metrics not updated for longer than their effective TTLs and returns their number.
+func (c *GaugeCollector) Clear(now time.Time) int {
+	expired, err := c.storage.ExpireBefore(now)
+	if err != nil {
+		logging.Logger("vault").Error(err, "clear expired metrics", "metric", c.mapping.Name)
+	}
+	return expired
+}
 
-	for labelsHash, singleMetric := range c.collection {
-		if singleMetric.LastUpdate.Add(c.mapping.TTL).Before(now) {
-			delete(c.collection, labelsHash)
+// Snapshot returns copies of all stored metrics.
+func (c *GaugeCollector) Snapshot() []StampedGaugeMetric {
+	metrics := make([]StampedGaugeMetric, 0, c.storage.Len())
+	err := c.storage.Range(func(_ uint64, m StampedGaugeMetric) bool {
+		metrics = append(metrics, m)
+		return true
+	})
+	if err != nil {
+		logging.Logger("vault").Error(err, "snapshot metrics", "metric", c.mapping.Name)
+	}
+	return metrics
+}
+
+// Restore puts previously stored metrics back and returns the number of restored metrics. TTLs are evaluated
+// with current rules, and expired metrics are skipped. Metrics stored since the start are kept as they are newer.
+func (c *GaugeCollector) Restore(now time.Time, metrics []StampedGaugeMetric) int {
+	restored := 0
+	for _, m := range metrics {
+		m.TTL = c.ttl(m.LabelValues)
+		if m.ExpiresAt().Before(now) {
+			continue
+		}
+		labelsHash := hashLabels(m.LabelValues)
+
+		lock := c.storeLock(labelsHash)
+		lock.Lock()
+		_, ok, err := c.storage.Get(labelsHash)
+		if err == nil && !ok {
+			err = c.storage.Put(labelsHash, m)
+			restored++
+		}
+		lock.Unlock()
+
+		if err != nil {
+			logging.Logger("vault").Error(err, "restore metric", "metric", c.mapping.Name)
 		}
 	}
+	return restored
+}
+
+// ttl returns the TTL of the first rule matching label values, or the mapping TTL.
+func (c *GaugeCollector) ttl(labelValues []string) time.Duration {
+	return effectiveTTL(c.ttlRules, c.mapping.TTL, labelValues)
+}
+
+func (c *GaugeCollector) storeLock(labelsHash uint64) *sync.Mutex {
+	return &c.storeLocks[labelsHash%storeLockShards]
+}
+
+// Len returns the number of stored metrics.
+func (c *GaugeCollector) Len() int {
+	return c.storage.Len()
+}
+
+// Drop removes all metrics together with the storage.
+func (c *GaugeCollector) Drop() error {
+	return c.storage.Drop()
 }
 
 func hashLabels(labels []string) uint64 {
@@ -126,3 +346,42 @@ func hashLabels(labels []string) uint64 {
 	_, _ = hasher.Write(hashbuf.Bytes())
 	return hasher.Sum64()
 }
+
+// exemplarMetric adds the exemplar to the counter metric on writing.
+type exemplarMetric struct {
+	prometheus.Metric
+	exemplar *dto.Exemplar
+}
+
+func (m *exemplarMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+	out.Counter.Exemplar = m.exemplar
+	return nil
+}
+
+func withExemplar(metric prometheus.Metric, s StampedGaugeMetric) (prometheus.Metric, error) {
+	labels := make([]*dto.LabelPair, 0, len(s.Exemplar))
+	runes := 0
+	for name, value := range s.Exemplar {
+		if !utf8.ValidString(value) {
+			return nil, fmt.Errorf("exemplar label %q value is not valid UTF-8", name)
+		}
+		runes += utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+		labels = append(labels, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	if runes > exemplarMaxRunes {
+		return nil, fmt.Errorf("exemplar labels have %d runes, exceeding the limit of %d", runes, exemplarMaxRunes)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+
+	return &exemplarMetric{
+		Metric: metric,
+		exemplar: &dto.Exemplar{
+			Label:     labels,
+			Value:     proto.Float64(s.Value),
+			Timestamp: timestamppb.New(s.LastUpdate),
+		},
+	}, nil
+}
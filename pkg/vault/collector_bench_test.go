@@ -0,0 +1,165 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+var benchmarkSeries = []int{10_000, 100_000, 1_000_000}
+
+func benchmarkSamples(series int) []Sample {
+	samples := make([]Sample, series)
+	for i := range samples {
+		samples[i] = Sample{
+			ID: fmt.Sprintf("uid-%d", i),
+			Labels: prometheus.Labels{
+				"type":               "Warning",
+				"involved_name":      fmt.Sprintf("pod-%d", i),
+				"involved_namespace": "default",
+				"reason":             "BackOff",
+			},
+			Value: 1,
+		}
+	}
+	return samples
+}
+
+func benchmarkCollector(b *testing.B, samples []Sample) *GaugeCollector {
+	collector := NewConstGaugeCollector(Mapping{
+		Name:       "test_metric",
+		LabelNames: []string{"type", "involved_name", "involved_namespace", "reason"},
+		TTL:        time.Hour,
+	})
+	now := time.Now()
+	for _, s := range samples {
+		_, err := collector.Store(now, s)
+		require.NoError(b, err)
+	}
+	return collector
+}
+
+func collectAll(collector *GaugeCollector) int {
+	ch := make(chan prometheus.Metric, 1024)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+
+	collected := 0
+	for range ch {
+		collected++
+	}
+	return collected
+}
+
+// BenchmarkStoreWithCollect measures stores of updated samples while scrapes run back to back.
+func BenchmarkStoreWithCollect(b *testing.B) {
+	for _, series := range benchmarkSeries {
+		b.Run(fmt.Sprintf("series=%d", series), func(b *testing.B) {
+			samples := benchmarkSamples(series)
+			collector := benchmarkCollector(b, samples)
+
+			stopCh := make(chan struct{})
+			done := make(chan struct{})
+			var scrapes int64
+			go func() {
+				defer close(done)
+				for {
+					select {
+					case <-stopCh:
+						return
+					default:
+						collectAll(collector)
+						atomic.AddInt64(&scrapes, 1)
+					}
+				}
+			}()
+
+			var next int64
+			now := time.Now()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&next, 1)
+					sample := samples[int(i)%series]
+					sample.Value = float64(i)
+					if _, err := collector.Store(now, sample); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+			b.StopTimer()
+
+			close(stopCh)
+			<-done
+			b.ReportMetric(float64(atomic.LoadInt64(&scrapes)), "scrapes")
+		})
+	}
+}
+
+func BenchmarkCollect(b *testing.B) {
+	for _, series := range benchmarkSeries {
+		b.Run(fmt.Sprintf("series=%d", series), func(b *testing.B) {
+			collector := benchmarkCollector(b, benchmarkSamples(series))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if collected := collectAll(collector); collected != series {
+					b.Fatalf("collected %d metrics, expected %d", collected, series)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreDoesNotWaitForCollect(t *testing.T) {
+	collector := NewConstGaugeCollector(Mapping{Name: "test_metric", LabelNames: []string{"name"}, TTL: time.Hour})
+	for i := 0; i < 1000; i++ {
+		_, err := collector.Store(time.Now(), Sample{Labels: prometheus.Labels{"name": fmt.Sprint(i)}, Value: 1})
+		require.NoError(t, err)
+	}
+
+	// The scrape is stuck on sending the first metric, e.g., to a slow client.
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	<-ch
+
+	stored := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			_, err := collector.Store(time.Now(), Sample{Labels: prometheus.Labels{"name": fmt.Sprint(i)}, Value: 2})
+			require.NoError(t, err)
+		}
+		close(stored)
+	}()
+
+	select {
+	case <-stored:
+	case <-time.After(5 * time.Second):
+		t.Fatal("store waits for the collect")
+	}
+
+	for range ch {
+	}
+}
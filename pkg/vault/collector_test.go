@@ -14,13 +14,16 @@
 package vault
 
 import (
+	"errors"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestCollector(t *testing.T) {
@@ -36,12 +39,12 @@ func TestCollector(t *testing.T) {
 			Samples: []Sample{
 				{
 					ID:        "metric-1",
-					Labels:    []string{"test-1"},
+					Labels:    prometheus.Labels{"name": "test-1"},
 					Timestamp: curTime,
 				},
 				{
 					ID:        "metric-2",
-					Labels:    []string{"test-2"},
+					Labels:    prometheus.Labels{"name": "test-2"},
 					Timestamp: curTime,
 				},
 			},
@@ -52,12 +55,12 @@ func TestCollector(t *testing.T) {
 			Samples: []Sample{
 				{
 					ID:        "metric-1",
-					Labels:    []string{"test-ok"},
+					Labels:    prometheus.Labels{"name": "test-ok"},
 					Timestamp: curTime,
 				},
 				{
 					ID:        "metric-2",
-					Labels:    []string{"test-expired"},
+					Labels:    prometheus.Labels{"name": "test-expired"},
 					Timestamp: curTime.Add(-3 * time.Hour),
 				},
 			},
@@ -68,7 +71,7 @@ func TestCollector(t *testing.T) {
 			Samples: []Sample{
 				{
 					ID:     "metric-1",
-					Labels: []string{"test-ok"},
+					Labels: prometheus.Labels{"name": "test-ok"},
 				},
 			},
 			Result: []string{"test-ok"},
@@ -78,7 +81,7 @@ func TestCollector(t *testing.T) {
 			Samples: []Sample{
 				{
 					ID:     "metric-1",
-					Labels: []string{"test-ok"},
+					Labels: prometheus.Labels{"name": "test-ok"},
 				},
 			},
 			Result: []string{"test-ok"},
@@ -87,9 +90,6 @@ func TestCollector(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.Name, func(t *testing.T) {
-			// To be sure that we have clean registry all the time
-			prometheus.DefaultRegisterer = prometheus.NewRegistry()
-
 			vault := NewVault()
 			err := vault.RegisterMappings([]Mapping{
 				{
@@ -144,7 +144,7 @@ func TestCollectorNoTimestamp(t *testing.T) {
 			Samples: []Sample{
 				{
 					ID:        "metric-1",
-					Labels:    []string{"test-ok"},
+					Labels:    prometheus.Labels{"name": "test-ok"},
 					Timestamp: curTime.Add(3 * time.Hour),
 				},
 			},
@@ -155,7 +155,7 @@ func TestCollectorNoTimestamp(t *testing.T) {
 			Samples: []Sample{
 				{
 					ID:     "metric-2",
-					Labels: []string{"test-ok"},
+					Labels: prometheus.Labels{"name": "test-ok"},
 				},
 			},
 			Result: curTime,
@@ -165,12 +165,12 @@ func TestCollectorNoTimestamp(t *testing.T) {
 			Samples: []Sample{
 				{
 					ID:     "metric-3",
-					Labels: []string{"test-ok"},
+					Labels: prometheus.Labels{"name": "test-ok"},
 					Value:  0,
 				},
 				{
 					ID:        "metric-3",
-					Labels:    []string{"test-ok"},
+					Labels:    prometheus.Labels{"name": "test-ok"},
 					Value:     1,
 					Timestamp: curTime.Add(3 * time.Hour),
 				},
@@ -182,12 +182,12 @@ func TestCollectorNoTimestamp(t *testing.T) {
 			Samples: []Sample{
 				{
 					ID:     "metric-4",
-					Labels: []string{"test-ok"},
+					Labels: prometheus.Labels{"name": "test-ok"},
 					Value:  1,
 				},
 				{
 					ID:        "metric-4",
-					Labels:    []string{"test-ok"},
+					Labels:    prometheus.Labels{"name": "test-ok"},
 					Value:     1,
 					Timestamp: curTime.Add(3 * time.Hour),
 				},
@@ -198,9 +198,6 @@ func TestCollectorNoTimestamp(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.Name, func(t *testing.T) {
-			// To be sure that we have clean registry all the time
-			prometheus.DefaultRegisterer = prometheus.NewRegistry()
-
 			vault := NewVault()
 			err := vault.RegisterMappings([]Mapping{
 				{
@@ -222,7 +219,7 @@ func TestCollectorNoTimestamp(t *testing.T) {
 			collector := vault.metrics["test_metric"].(*GaugeCollector)
 
 			metric := StampedGaugeMetric{}
-			for _, m := range collector.collection {
+			for _, m := range collector.Snapshot() {
 				metric = m
 				break
 			}
@@ -231,3 +228,353 @@ func TestCollectorNoTimestamp(t *testing.T) {
 		})
 	}
 }
+
+func TestCollectorExemplar(t *testing.T) {
+	curTime := time.Now()
+
+	tests := []struct {
+		Name     string
+		Type     string
+		Exemplar prometheus.Labels
+		Result   []*dto.LabelPair
+	}{
+		{
+			Name:     "Counter with exemplar",
+			Type:     CounterType,
+			Exemplar: prometheus.Labels{"event_uid": "uid-1", "involved_uid": "uid-2"},
+			Result: []*dto.LabelPair{
+				{Name: proto.String("event_uid"), Value: proto.String("uid-1")},
+				{Name: proto.String("involved_uid"), Value: proto.String("uid-2")},
+			},
+		},
+		{
+			Name:     "Gauge ignores exemplar",
+			Type:     GaugeType,
+			Exemplar: prometheus.Labels{"event_uid": "uid-1"},
+		},
+		{
+			Name:     "Too long exemplar is dropped",
+			Type:     CounterType,
+			Exemplar: prometheus.Labels{"event_uid": strings.Repeat("u", exemplarMaxRunes)},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			collector := NewConstGaugeCollector(Mapping{Name: "test_metric", LabelNames: []string{"name"}, Type: tc.Type, TTL: time.Hour})
+			collector.Store(curTime, Sample{Labels: prometheus.Labels{"name": "test"}, Value: 3, Timestamp: curTime, Exemplar: tc.Exemplar})
+
+			metricsCh := make(chan prometheus.Metric, 1)
+			collector.Collect(metricsCh)
+			close(metricsCh)
+
+			metric, ok := <-metricsCh
+			require.True(t, ok)
+
+			var convertedMetric dto.Metric
+			require.NoError(t, metric.Write(&convertedMetric))
+			exemplar := convertedMetric.GetCounter().GetExemplar()
+
+			if tc.Result == nil {
+				require.Nil(t, exemplar)
+				return
+			}
+
+			require.Equal(t, tc.Result, exemplar.Label)
+			require.Equal(t, float64(3), exemplar.GetValue())
+			require.True(t, exemplar.Timestamp.AsTime().Equal(curTime))
+		})
+	}
+}
+
+func TestCollectorTimestamps(t *testing.T) {
+	curTime := time.Unix(1600000000, 0)
+
+	collector := NewConstGaugeCollector(Mapping{
+		Name:               "test_metric",
+		LabelNames:         []string{"name"},
+		TTL:                time.Hour,
+		FirstTimestampName: "test_first_timestamp_seconds",
+		LastTimestampName:  "test_last_timestamp_seconds",
+		ExplicitTimestamps: true,
+	})
+	collector.Store(curTime, Sample{
+		Labels:         prometheus.Labels{"name": "test"},
+		Value:          1,
+		Timestamp:      curTime.Add(-time.Minute),
+		FirstTimestamp: curTime.Add(-time.Hour),
+	})
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(collector))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	result := make(map[string]float64)
+	for _, family := range families {
+		metric := family.GetMetric()[0]
+		result[family.GetName()] = metric.GetGauge().GetValue()
+
+		if family.GetName() == "test_metric" {
+			require.Equal(t, curTime.Add(-time.Minute).UnixMilli(), metric.GetTimestampMs())
+		}
+	}
+
+	require.Equal(t, map[string]float64{
+		"test_metric":                  1,
+		"test_first_timestamp_seconds": float64(curTime.Add(-time.Hour).Unix()),
+		"test_last_timestamp_seconds":  float64(curTime.Add(-time.Minute).Unix()),
+	}, result)
+}
+
+func TestVaultsDoNotShareRegistry(t *testing.T) {
+	mappings := []Mapping{{Name: "test_metric", Help: "Test", LabelNames: []string{"name"}, TTL: time.Hour}}
+
+	first := NewVault()
+	require.NoError(t, first.RegisterMappings(mappings))
+
+	second := NewVault()
+	require.NoError(t, second.RegisterMappings(mappings))
+
+	require.NoError(t, first.Store("test_metric", Sample{ID: "1", Labels: prometheus.Labels{"name": "first"}, Value: 1}))
+
+	families, err := first.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	require.Len(t, families[0].GetMetric(), 1)
+
+	families, err = second.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 0)
+
+	// The same mapping cannot be registered twice in one vault.
+	require.Error(t, first.RegisterMappings(mappings))
+}
+
+func TestVaultSamples(t *testing.T) {
+	curTime := time.Unix(1600000000, 0)
+
+	v := NewVault()
+	v.now = func() time.Time { return curTime }
+	require.NoError(t, v.RegisterMappings([]Mapping{{Name: "test_metric", LabelNames: []string{"name", "reason"}, TTL: time.Hour}}))
+	require.NoError(t, v.Store("test_metric", Sample{ID: "uid-1", Labels: prometheus.Labels{"name": "pod", "reason": "BackOff"}, Value: 2}))
+
+	require.Equal(t, []StoredSample{{
+		Metric:     "test_metric",
+		Labels:     map[string]string{"name": "pod", "reason": "BackOff"},
+		Value:      2,
+		FirstSeen:  curTime,
+		LastUpdate: curTime,
+		TTL:        time.Hour,
+		ExpiresAt:  curTime.Add(time.Hour),
+		ID:         "uid-1",
+	}}, v.Samples())
+
+	status := v.MappingsStatus()
+	require.Len(t, status, 1)
+	require.Equal(t, "test_metric", status[0].Name)
+	require.Equal(t, 1, status[0].Series)
+}
+
+func TestVaultUnregisterMapping(t *testing.T) {
+	v := NewVault()
+	mappings := []Mapping{{Name: "test_metric", LabelNames: []string{"name"}, TTL: time.Hour}}
+	require.NoError(t, v.RegisterMappings(mappings))
+	require.NoError(t, v.Store("test_metric", Sample{ID: "uid-1", Labels: prometheus.Labels{"name": "pod"}, Value: 1}))
+
+	unregistered, err := v.UnregisterMapping("test_metric")
+	require.NoError(t, err)
+	require.True(t, unregistered)
+	unregistered, err = v.UnregisterMapping("test_metric")
+	require.NoError(t, err)
+	require.False(t, unregistered)
+	require.ErrorIs(t, v.Store("test_metric", Sample{Labels: prometheus.Labels{"name": "pod"}}), ErrUnknownMapping)
+	require.Empty(t, v.Samples())
+
+	families, err := v.Gather()
+	require.NoError(t, err)
+	require.Empty(t, families)
+
+	// The metric can be registered again with other labels.
+	mappings[0].LabelNames = []string{"name", "reason"}
+	require.NoError(t, v.RegisterMappings(mappings))
+	require.Empty(t, v.Samples())
+}
+
+func TestVaultStoreListener(t *testing.T) {
+	v := NewVault()
+	require.NoError(t, v.RegisterMappings([]Mapping{{Name: "test_metric", LabelNames: []string{"name"}, TTL: time.Hour}}))
+
+	var stored []StoredSample
+	v.AddStoreListener(StoreListenerFunc(func(sample StoredSample) { stored = append(stored, sample) }))
+
+	require.NoError(t, v.Store("test_metric", Sample{ID: "uid-1", Labels: prometheus.Labels{"name": "pod"}, Value: 1}))
+	require.NoError(t, v.Store("test_metric", Sample{ID: "uid-1", Labels: prometheus.Labels{"name": "pod"}, Value: 2}))
+
+	require.Len(t, stored, 2)
+	require.Equal(t, map[string]string{"name": "pod"}, stored[1].Labels)
+	require.Equal(t, float64(2), stored[1].Value)
+
+	idle := &idleListener{}
+	v.AddStoreListener(idle)
+	require.NoError(t, v.Store("test_metric", Sample{ID: "uid-1", Labels: prometheus.Labels{"name": "pod"}, Value: 3}))
+	require.Len(t, stored, 3)
+	require.Zero(t, idle.stored)
+}
+
+// idleListener never listens, so it must not receive samples.
+type idleListener struct{ stored int }
+
+func (l *idleListener) Listening() bool { return false }
+
+func (l *idleListener) OnStore(StoredSample) { l.stored++ }
+
+func TestCollectorTTLRules(t *testing.T) {
+	curTime := time.Unix(1600000000, 0)
+
+	v := NewVault()
+	v.now = func() time.Time { return curTime }
+	require.NoError(t, v.RegisterMappings([]Mapping{{
+		Name:       "test_metric",
+		LabelNames: []string{"type", "reason"},
+		TTL:        time.Hour,
+		TTLRules: []TTLRule{
+			{Match: map[string]string{"reason": "NodeNotReady|Evicted"}, TTL: 72 * time.Hour},
+			{Match: map[string]string{"type": "Warning"}, TTL: 24 * time.Hour},
+			{Match: map[string]string{"type": "Normal"}, TTL: 10 * time.Minute},
+		},
+	}}))
+
+	for _, labels := range []prometheus.Labels{
+		{"type": "Warning", "reason": "Evicted"},
+		{"type": "Warning", "reason": "BackOff"},
+		{"type": "Normal", "reason": "Pulled"},
+		{"type": "Custom", "reason": "Pulled"},
+		// Regular expressions are anchored.
+		{"type": "Normal", "reason": "NodeNotReadyAgain"},
+	} {
+		require.NoError(t, v.Store("test_metric", Sample{Labels: labels, Value: 1}))
+	}
+
+	ttls := make(map[string]time.Duration)
+	for _, s := range v.Samples() {
+		ttls[s.Labels["type"]+"/"+s.Labels["reason"]] = s.TTL
+		require.Equal(t, s.LastUpdate.Add(s.TTL), s.ExpiresAt)
+	}
+	require.Equal(t, map[string]time.Duration{
+		"Warning/Evicted":          72 * time.Hour,
+		"Warning/BackOff":          24 * time.Hour,
+		"Normal/Pulled":            10 * time.Minute,
+		"Custom/Pulled":            time.Hour,
+		"Normal/NodeNotReadyAgain": 10 * time.Minute,
+	}, ttls)
+
+	v.now = func() time.Time { return curTime.Add(2 * time.Hour) }
+	v.RemoveStaleMetrics()
+
+	remaining := make([]string, 0, 2)
+	for _, s := range v.Samples() {
+		remaining = append(remaining, s.Labels["reason"])
+	}
+	sort.Strings(remaining)
+	require.Equal(t, []string{"BackOff", "Evicted"}, remaining)
+}
+
+func TestInvalidTTLRules(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Rule  TTLRule
+		Error string
+	}{
+		{Name: "Unknown label", Rule: TTLRule{Match: map[string]string{"unknown": "x"}, TTL: time.Hour}, Error: `unknown label "unknown"`},
+		{Name: "Invalid regexp", Rule: TTLRule{Match: map[string]string{"type": "("}, TTL: time.Hour}, Error: "missing closing )"},
+		{Name: "No TTL", Rule: TTLRule{Match: map[string]string{"type": "Warning"}}, Error: "ttl must be positive"},
+		{Name: "No match", Rule: TTLRule{TTL: time.Hour}, Error: "match at least one label"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := NewVault().RegisterMappings([]Mapping{{
+				Name:       "test_metric",
+				LabelNames: []string{"type"},
+				TTL:        time.Hour,
+				TTLRules:   []TTLRule{tc.Rule},
+			}})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.Error)
+		})
+	}
+}
+
+func TestInvalidMappings(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Mapping Mapping
+		Error   string
+	}{
+		{Name: "Invalid metric name", Mapping: Mapping{Name: "test-metric"}, Error: `invalid metric name "test-metric"`},
+		{Name: "Invalid label name", Mapping: Mapping{Name: "test_metric", LabelNames: []string{"involved.name"}}, Error: `invalid label name "involved.name"`},
+		{Name: "Duplicate label name", Mapping: Mapping{Name: "test_metric", LabelNames: []string{"name", "name"}}, Error: `duplicate label name "name"`},
+		{Name: "Invalid timestamp name", Mapping: Mapping{Name: "test_metric", FirstTimestampName: "first timestamp"}, Error: `invalid timestamp metric name "first timestamp"`},
+		{Name: "Unknown type", Mapping: Mapping{Name: "test_metric", Type: "histogram"}, Error: `unknown metric type "histogram"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := NewVault().RegisterMappings([]Mapping{tc.Mapping})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.Error)
+		})
+	}
+}
+
+func TestStoreLabels(t *testing.T) {
+	v := NewVault()
+	require.NoError(t, v.RegisterMappings([]Mapping{{Name: "test_metric", LabelNames: []string{"name", "reason"}, TTL: time.Hour}}))
+
+	err := v.Store("test_metric", Sample{Labels: prometheus.Labels{"name": "pod", "namespace": "default"}, Value: 1})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `label "namespace" is not one of mapping labels [name reason]`)
+	require.Empty(t, v.Samples())
+
+	// Missing labels are empty, the same as in Prometheus.
+	require.NoError(t, v.Store("test_metric", Sample{Labels: prometheus.Labels{"reason": "BackOff"}, Value: 1}))
+	require.NoError(t, v.Store("test_metric", Sample{Labels: prometheus.Labels{"name": "", "reason": "BackOff"}, Value: 2}))
+
+	samples := v.Samples()
+	require.Len(t, samples, 1)
+	require.Equal(t, map[string]string{"name": "", "reason": "BackOff"}, samples[0].Labels)
+	require.Equal(t, float64(2), samples[0].Value)
+}
+
+func TestStoreErrors(t *testing.T) {
+	v := NewVault()
+	require.NoError(t, v.RegisterMappings([]Mapping{{Name: "test_metric", LabelNames: []string{"name"}, TTL: time.Hour, SeriesLimit: 1}}))
+	require.NoError(t, v.Store("test_metric", Sample{Labels: prometheus.Labels{"name": "first"}, Value: 1}))
+
+	tests := []struct {
+		Name   string
+		Metric string
+		Labels prometheus.Labels
+		Error  error
+		Reason string
+	}{
+		{Name: "Unknown mapping", Metric: "unknown_metric", Labels: prometheus.Labels{"name": "first"}, Error: ErrUnknownMapping, Reason: "unknown_mapping"},
+		{Name: "Unknown label", Metric: "test_metric", Labels: prometheus.Labels{"namespace": "default"}, Error: ErrLabelMismatch, Reason: "label_mismatch"},
+		{Name: "Invalid UTF-8", Metric: "test_metric", Labels: prometheus.Labels{"name": "\xff"}, Error: ErrInvalidUTF8, Reason: "invalid_utf8"},
+		{Name: "Limit exceeded", Metric: "test_metric", Labels: prometheus.Labels{"name": "second"}, Error: ErrLimitExceeded, Reason: "limit_exceeded"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := v.Store(tc.Metric, Sample{Labels: tc.Labels, Value: 1})
+			require.True(t, errors.Is(err, tc.Error), "unexpected error: %v", err)
+			require.Equal(t, tc.Reason, ErrorReason(err))
+		})
+	}
+
+	// Existing series are updated over the limit.
+	require.NoError(t, v.Store("test_metric", Sample{Labels: prometheus.Labels{"name": "first"}, Value: 2}))
+	require.Len(t, v.Samples(), 1)
+}
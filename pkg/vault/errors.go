@@ -0,0 +1,45 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import "errors"
+
+// Errors returned by MetricsVault.Store, use errors.Is or ErrorReason to tell them apart.
+var (
+	// ErrUnknownMapping means no mapping is registered with the name.
+	ErrUnknownMapping = errors.New("unknown mapping")
+	// ErrLabelMismatch means sample labels do not match label names of the mapping.
+	ErrLabelMismatch = errors.New("labels do not match the mapping")
+	// ErrInvalidUTF8 means a label value is not valid UTF-8 and cannot be exposed.
+	ErrInvalidUTF8 = errors.New("invalid UTF-8")
+	// ErrLimitExceeded means the mapping already holds the max number of series, and the sample is a new one.
+	ErrLimitExceeded = errors.New("series limit exceeded")
+)
+
+// ErrorReason returns the short reason of the Store error for metric labels, "storage" for errors
+// of the storage itself.
+func ErrorReason(err error) string {
+	switch {
+	case errors.Is(err, ErrUnknownMapping):
+		return "unknown_mapping"
+	case errors.Is(err, ErrLabelMismatch):
+		return "label_mismatch"
+	case errors.Is(err, ErrInvalidUTF8):
+		return "invalid_utf8"
+	case errors.Is(err, ErrLimitExceeded):
+		return "limit_exceeded"
+	default:
+		return "storage"
+	}
+}
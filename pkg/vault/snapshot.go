@@ -0,0 +1,193 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// SnapshotVersion is the version of the snapshot format. Snapshots of other versions are not restored.
+	SnapshotVersion = 2
+
+	snapshotMagic = "events_exporter_vault"
+)
+
+// snapshotMapping keeps metrics of the mapping with its label names, because label values are stored by position.
+type snapshotMapping struct {
+	LabelNames []string         `json:"label_names"`
+	Metrics    []snapshotMetric `json:"metrics"`
+}
+
+// snapshotMetric is the stored metric in the snapshot. The format is decoupled from StampedGaugeMetric,
+// so collector internals can change without breaking snapshots.
+type snapshotMetric struct {
+	LabelValues []string          `json:"labels"`
+	Value       float64           `json:"value"`
+	FirstSeen   time.Time         `json:"first_seen"`
+	LastUpdate  time.Time         `json:"last_update"`
+	TTL         time.Duration     `json:"ttl,omitempty"`
+	Exemplar    map[string]string `json:"exemplar,omitempty"`
+	ID          string            `json:"id,omitempty"`
+}
+
+func newSnapshotMetric(m StampedGaugeMetric) snapshotMetric {
+	return snapshotMetric{
+		LabelValues: m.LabelValues,
+		Value:       m.Value,
+		FirstSeen:   m.FirstSeen,
+		LastUpdate:  m.LastUpdate,
+		TTL:         m.TTL,
+		Exemplar:    m.Exemplar,
+		ID:          m.ID,
+	}
+}
+
+func (s snapshotMetric) stampedGaugeMetric() StampedGaugeMetric {
+	return StampedGaugeMetric{
+		Value:       s.Value,
+		LabelValues: s.LabelValues,
+		FirstSeen:   s.FirstSeen,
+		LastUpdate:  s.LastUpdate,
+		TTL:         s.TTL,
+		Exemplar:    s.Exemplar,
+		ID:          s.ID,
+	}
+}
+
+// Save writes the snapshot of all stored metrics. The snapshot starts with the header line containing the format
+// version and the checksum of the gzipped JSON payload that follows.
+func (v *MetricsVault) Save(w io.Writer) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	metrics := make(map[string]snapshotMapping, len(v.metrics))
+	for name, m := range v.metrics {
+		stored := m.Snapshot()
+		snapshot := snapshotMapping{
+			LabelNames: v.mappings[name].LabelNames,
+			Metrics:    make([]snapshotMetric, 0, len(stored)),
+		}
+		for _, s := range stored {
+			snapshot.Metrics = append(snapshot.Metrics, newSnapshotMetric(s))
+		}
+		metrics[name] = snapshot
+	}
+
+	var payload bytes.Buffer
+	gz := gzip.NewWriter(&payload)
+	if err := json.NewEncoder(gz).Encode(metrics); err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("compress snapshot: %w", err)
+	}
+
+	checksum := sha256.Sum256(payload.Bytes())
+	if _, err := fmt.Fprintf(w, "%s v%d sha256:%s\n", snapshotMagic, SnapshotVersion, hex.EncodeToString(checksum[:])); err != nil {
+		return fmt.Errorf("write snapshot header: %w", err)
+	}
+	if _, err := w.Write(payload.Bytes()); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	return nil
+}
+
+// Restore loads metrics from the snapshot into registered mappings. Metrics of unknown mappings, mappings with
+// other label names, e.g., after relabeling was changed, and metrics that have already expired are skipped.
+// It returns the number of restored metrics.
+func (v *MetricsVault) Restore(r io.Reader) (int, error) {
+	reader := bufio.NewReader(r)
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, fmt.Errorf("read snapshot header: %w", err)
+	}
+
+	var (
+		magic    string
+		version  int
+		checksum string
+	)
+	if _, err := fmt.Sscanf(header, "%s v%d sha256:%s\n", &magic, &version, &checksum); err != nil || magic != snapshotMagic {
+		return 0, fmt.Errorf("invalid snapshot header %q", header)
+	}
+	if version != SnapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d, expected %d", version, SnapshotVersion)
+	}
+
+	payload, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, fmt.Errorf("read snapshot: %w", err)
+	}
+	actual := sha256.Sum256(payload)
+	if hex.EncodeToString(actual[:]) != checksum {
+		return 0, fmt.Errorf("snapshot checksum mismatch")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return 0, fmt.Errorf("decompress snapshot: %w", err)
+	}
+	defer gz.Close()
+
+	var metrics map[string]snapshotMapping
+	if err := json.NewDecoder(gz).Decode(&metrics); err != nil {
+		return 0, fmt.Errorf("decode snapshot: %w", err)
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	now := v.now()
+	restored := 0
+	for name, snapshot := range metrics {
+		collector, ok := v.metrics[name]
+		if !ok {
+			continue
+		}
+		if !equalStrings(snapshot.LabelNames, v.mappings[name].LabelNames) {
+			continue
+		}
+
+		stored := make([]StampedGaugeMetric, 0, len(snapshot.Metrics))
+		for _, s := range snapshot.Metrics {
+			if len(s.LabelValues) != len(snapshot.LabelNames) {
+				continue
+			}
+			stored = append(stored, s.stampedGaugeMetric())
+		}
+		restored += collector.Restore(now, stored)
+	}
+	return restored, nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func snapshotVault(t *testing.T, now time.Time) *MetricsVault {
+	v := NewVault()
+	v.now = func() time.Time { return now }
+	require.NoError(t, v.RegisterMappings([]Mapping{{Name: "test_metric", LabelNames: []string{"name"}, TTL: time.Hour}}))
+	return v
+}
+
+func TestVaultSnapshot(t *testing.T) {
+	curTime := time.Unix(1600000000, 0)
+
+	v := snapshotVault(t, curTime)
+	require.NoError(t, v.Store("test_metric", Sample{
+		ID:       "uid-1",
+		Labels:   prometheus.Labels{"name": "fresh"},
+		Value:    3,
+		Exemplar: prometheus.Labels{"event_uid": "uid-1"},
+	}))
+	require.NoError(t, v.Store("test_metric", Sample{ID: "uid-2", Labels: prometheus.Labels{"name": "old"}, Value: 1, Timestamp: curTime.Add(-50 * time.Minute)}))
+
+	var snapshot bytes.Buffer
+	require.NoError(t, v.Save(&snapshot))
+
+	restoredVault := snapshotVault(t, curTime.Add(30*time.Minute))
+	restored, err := restoredVault.Restore(bytes.NewReader(snapshot.Bytes()))
+	require.NoError(t, err)
+	// The old sample has expired by the restore time.
+	require.Equal(t, 1, restored)
+
+	samples := restoredVault.Samples()
+	require.Len(t, samples, 1)
+	require.Equal(t, "uid-1", samples[0].ID)
+	require.Equal(t, float64(3), samples[0].Value)
+	require.True(t, curTime.Equal(samples[0].LastUpdate))
+
+	// The value change detection continues from the restored state.
+	require.NoError(t, restoredVault.Store("test_metric", Sample{ID: "uid-1", Labels: prometheus.Labels{"name": "fresh"}, Value: 3}))
+	require.True(t, curTime.Equal(restoredVault.Samples()[0].LastUpdate))
+}
+
+func TestVaultSnapshotLabelNames(t *testing.T) {
+	curTime := time.Unix(1600000000, 0)
+
+	v := NewVault()
+	v.now = func() time.Time { return curTime }
+	require.NoError(t, v.RegisterMappings([]Mapping{
+		{Name: "test_metric", LabelNames: []string{"name", "reason"}, TTL: time.Hour},
+		{Name: "other_metric", LabelNames: []string{"name"}, TTL: time.Hour},
+	}))
+	require.NoError(t, v.Store("test_metric", Sample{Labels: prometheus.Labels{"name": "pod", "reason": "BackOff"}, Value: 1}))
+	require.NoError(t, v.Store("other_metric", Sample{Labels: prometheus.Labels{"name": "pod"}, Value: 1}))
+
+	var snapshot bytes.Buffer
+	require.NoError(t, v.Save(&snapshot))
+
+	// Labels of the first mapping are reordered, values would be restored under wrong names.
+	restoredVault := NewVault()
+	restoredVault.now = func() time.Time { return curTime }
+	require.NoError(t, restoredVault.RegisterMappings([]Mapping{
+		{Name: "test_metric", LabelNames: []string{"reason", "name"}, TTL: time.Hour},
+		{Name: "other_metric", LabelNames: []string{"name"}, TTL: time.Hour},
+	}))
+	restored, err := restoredVault.Restore(bytes.NewReader(snapshot.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, 1, restored)
+
+	samples := restoredVault.Samples()
+	require.Len(t, samples, 1)
+	require.Equal(t, "other_metric", samples[0].Metric)
+}
+
+func TestVaultSnapshotValidation(t *testing.T) {
+	v := snapshotVault(t, time.Now())
+	require.NoError(t, v.Store("test_metric", Sample{Labels: prometheus.Labels{"name": "test"}, Value: 1}))
+
+	var snapshot bytes.Buffer
+	require.NoError(t, v.Save(&snapshot))
+	header, payload, _ := strings.Cut(snapshot.String(), "\n")
+
+	tests := []struct {
+		name     string
+		snapshot string
+		err      string
+	}{
+		{name: "corrupted", snapshot: header + "\n" + payload[:len(payload)-1], err: "checksum mismatch"},
+		{name: "unknown version", snapshot: strings.Replace(header, " v2 ", " v1 ", 1) + "\n" + payload, err: "unsupported snapshot version"},
+		{name: "not a snapshot", snapshot: "{}\n", err: "invalid snapshot header"},
+		{name: "empty", snapshot: "", err: "read snapshot header"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := snapshotVault(t, time.Now()).Restore(strings.NewReader(tt.snapshot))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import "time"
+
+// Storage keeps metrics of a single collector by their labels hash. Implementations must be safe
+// for concurrent use.
+type Storage interface {
+	// Get returns the metric stored by the key.
+	Get(key uint64) (StampedGaugeMetric, bool, error)
+	// Put stores the metric by the key replacing the previous one.
+	Put(key uint64, metric StampedGaugeMetric) error
+	// Delete removes the metric by the key if it exists.
+	Delete(key uint64) error
+	// Range calls fn for every stored metric until fn returns false. fn must not modify the storage.
+	Range(fn func(key uint64, metric StampedGaugeMetric) bool) error
+	// ExpireBefore removes metrics expiring before the time and returns their number.
+	ExpireBefore(t time.Time) (int, error)
+	// Len returns the number of stored metrics.
+	Len() int
+	// Drop removes all metrics together with the storage itself, e.g., the database bucket. The storage must
+	// not be used after that.
+	Drop() error
+}
+
+// StorageFactory creates the storage for the mapping.
+type StorageFactory func(mapping Mapping) (Storage, error)
@@ -0,0 +1,290 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	_ Storage = (*BoltStorage)(nil)
+
+	boltMetricsBucket = []byte("metrics")
+	// boltExpiryBucket indexes metrics by their expiration time, so expiration does not scan all metrics.
+	boltExpiryBucket = []byte("expiry")
+
+	// errBoltBucketDropped is returned by storages used after Drop.
+	errBoltBucketDropped = errors.New("bolt bucket is dropped")
+)
+
+// BoltDB keeps metrics of all mappings on disk in a single bbolt database, a bucket per mapping.
+// It keeps memory usage flat for huge clusters at the cost of slower writes.
+type BoltDB struct {
+	db *bolt.DB
+}
+
+// OpenBoltDB opens or creates the database file. Metrics stored before are available right away.
+func OpenBoltDB(path string) (*BoltDB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt database: %w", err)
+	}
+	return &BoltDB{db: db}, nil
+}
+
+// Close closes the database file.
+func (b *BoltDB) Close() error {
+	return b.db.Close()
+}
+
+// StorageFactory creates the storage in the database bucket named after the mapping.
+func (b *BoltDB) StorageFactory(mapping Mapping) (Storage, error) {
+	s := &BoltStorage{db: b.db, bucket: []byte(mapping.Name)}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(s.bucket)
+		if err != nil {
+			return err
+		}
+		metrics, err := bucket.CreateBucketIfNotExists(boltMetricsBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := bucket.CreateBucketIfNotExists(boltExpiryBucket); err != nil {
+			return err
+		}
+		s.len = int64(metrics.Stats().KeyN)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create bolt bucket %q: %w", mapping.Name, err)
+	}
+	return s, nil
+}
+
+// BoltStorage keeps metrics of a single mapping in the bbolt bucket.
+type BoltStorage struct {
+	db     *bolt.DB
+	bucket []byte
+	len    int64
+}
+
+// buckets returns nested buckets of metrics and their expiration index.
+func (s *BoltStorage) buckets(tx *bolt.Tx) (metrics, expiry *bolt.Bucket, err error) {
+	bucket := tx.Bucket(s.bucket)
+	if bucket == nil {
+		return nil, nil, fmt.Errorf("%w: %q", errBoltBucketDropped, s.bucket)
+	}
+	return bucket.Bucket(boltMetricsBucket), bucket.Bucket(boltExpiryBucket), nil
+}
+
+func (s *BoltStorage) Get(key uint64) (StampedGaugeMetric, bool, error) {
+	var (
+		metric StampedGaugeMetric
+		found  bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		metrics, _, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		value := metrics.Get(boltKey(key))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return decodeBoltMetric(value, &metric)
+	})
+	return metric, found, err
+}
+
+func (s *BoltStorage) Put(key uint64, metric StampedGaugeMetric) error {
+	value, err := json.Marshal(newSnapshotMetric(metric))
+	if err != nil {
+		return fmt.Errorf("encode metric: %w", err)
+	}
+
+	added := false
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		metrics, expiry, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+
+		k := boltKey(key)
+		if previous := metrics.Get(k); previous != nil {
+			var stored StampedGaugeMetric
+			if err := decodeBoltMetric(previous, &stored); err != nil {
+				return err
+			}
+			if err := expiry.Delete(boltExpiryKey(stored.ExpiresAt(), key)); err != nil {
+				return err
+			}
+		} else {
+			added = true
+		}
+
+		if err := metrics.Put(k, value); err != nil {
+			return err
+		}
+		return expiry.Put(boltExpiryKey(metric.ExpiresAt(), key), nil)
+	})
+	if err == nil && added {
+		atomic.AddInt64(&s.len, 1)
+	}
+	return err
+}
+
+func (s *BoltStorage) Delete(key uint64) error {
+	deleted := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		metrics, expiry, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+
+		k := boltKey(key)
+		previous := metrics.Get(k)
+		if previous == nil {
+			return nil
+		}
+
+		var stored StampedGaugeMetric
+		if err := decodeBoltMetric(previous, &stored); err != nil {
+			return err
+		}
+		if err := expiry.Delete(boltExpiryKey(stored.ExpiresAt(), key)); err != nil {
+			return err
+		}
+		deleted = true
+		return metrics.Delete(k)
+	})
+	if err == nil && deleted {
+		atomic.AddInt64(&s.len, -1)
+	}
+	return err
+}
+
+func (s *BoltStorage) Range(fn func(key uint64, metric StampedGaugeMetric) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		metrics, _, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		cursor := metrics.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var metric StampedGaugeMetric
+			if err := decodeBoltMetric(v, &metric); err != nil {
+				return err
+			}
+			if !fn(binary.BigEndian.Uint64(k), metric) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) ExpireBefore(t time.Time) (int, error) {
+	expired := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		metrics, expiry, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+
+		bound := boltTimestamp(t)
+
+		// Keys are collected first, because deleting under the cursor skips elements.
+		var expiredKeys [][]byte
+		cursor := expiry.Cursor()
+		for k, _ := cursor.First(); k != nil && binary.BigEndian.Uint64(k[:8]) < bound; k, _ = cursor.Next() {
+			expiredKeys = append(expiredKeys, append([]byte(nil), k...))
+		}
+
+		for _, k := range expiredKeys {
+			if err := expiry.Delete(k); err != nil {
+				return err
+			}
+			if err := metrics.Delete(k[8:]); err != nil {
+				return err
+			}
+		}
+		expired = len(expiredKeys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	atomic.AddInt64(&s.len, -int64(expired))
+	return expired, nil
+}
+
+func (s *BoltStorage) Len() int {
+	return int(atomic.LoadInt64(&s.len))
+}
+
+// Drop deletes the bucket of the mapping, so the mapping registered again starts from scratch.
+func (s *BoltStorage) Drop() error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket(s.bucket)
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("delete bolt bucket %q: %w", s.bucket, err)
+	}
+	atomic.StoreInt64(&s.len, 0)
+	return nil
+}
+
+func boltKey(key uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, key)
+	return k
+}
+
+// boltExpiryKey sorts metrics by the expiration time first.
+func boltExpiryKey(expiresAt time.Time, key uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, boltTimestamp(expiresAt))
+	binary.BigEndian.PutUint64(k[8:], key)
+	return k
+}
+
+// boltTimestamp converts the time to the number preserving the order, times before the epoch are the same.
+func boltTimestamp(t time.Time) uint64 {
+	if t.Before(time.Unix(0, 0)) {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func decodeBoltMetric(value []byte, metric *StampedGaugeMetric) error {
+	var stored snapshotMetric
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return fmt.Errorf("decode metric: %w", err)
+	}
+	*metric = stored.stampedGaugeMetric()
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// memoryStorageShards is the number of independently locked maps. Keys are already hashes, so they are evenly spread.
+const memoryStorageShards = 256
+
+var _ Storage = (*MemoryStorage)(nil)
+
+// MemoryStorage keeps metrics in sharded maps, so writers of different metrics rarely wait for each other.
+type MemoryStorage struct {
+	shards [memoryStorageShards]memoryShard
+}
+
+type memoryShard struct {
+	mu      sync.RWMutex
+	metrics map[uint64]StampedGaugeMetric
+	// expiry indexes metrics by their expiration time, so expiration only touches metrics that are due.
+	// Entries of updated and deleted metrics are left in place and skipped once they are popped.
+	expiry expiryHeap
+}
+
+// expiryEntry is the metric key with its expiration time at the moment it was indexed.
+type expiryEntry struct {
+	expiresAt time.Time
+	key       uint64
+}
+
+// expiryHeap is the min-heap of expiry entries by the expiration time.
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryEntry)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// expiryHeapSlack is how many outdated entries the heap may have over the number of metrics before compaction.
+const expiryHeapSlack = 64
+
+// NewMemoryStorage returns the empty in-memory storage.
+func NewMemoryStorage() *MemoryStorage {
+	s := &MemoryStorage{}
+	for i := range s.shards {
+		s.shards[i].metrics = make(map[uint64]StampedGaugeMetric)
+	}
+	return s
+}
+
+// MemoryStorageFactory creates in-memory storages for all mappings.
+func MemoryStorageFactory(Mapping) (Storage, error) {
+	return NewMemoryStorage(), nil
+}
+
+func (s *MemoryStorage) shard(key uint64) *memoryShard {
+	return &s.shards[key%memoryStorageShards]
+}
+
+func (s *MemoryStorage) Get(key uint64) (StampedGaugeMetric, bool, error) {
+	shard := s.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	metric, ok := shard.metrics[key]
+	return metric, ok, nil
+}
+
+func (s *MemoryStorage) Put(key uint64, metric StampedGaugeMetric) error {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	previous, ok := shard.metrics[key]
+	shard.metrics[key] = metric
+	if !ok || !previous.ExpiresAt().Equal(metric.ExpiresAt()) {
+		shard.index(key, metric.ExpiresAt())
+	}
+	return nil
+}
+
+func (shard *memoryShard) index(key uint64, expiresAt time.Time) {
+	heap.Push(&shard.expiry, expiryEntry{expiresAt: expiresAt, key: key})
+
+	// Often updated metrics leave many outdated entries behind, rebuild the heap from actual metrics.
+	if len(shard.expiry) > 2*len(shard.metrics)+expiryHeapSlack {
+		shard.expiry = shard.expiry[:0]
+		for k, m := range shard.metrics {
+			shard.expiry = append(shard.expiry, expiryEntry{expiresAt: m.ExpiresAt(), key: k})
+		}
+		heap.Init(&shard.expiry)
+	}
+}
+
+func (s *MemoryStorage) Delete(key uint64) error {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	delete(shard.metrics, key)
+	return nil
+}
+
+// Range iterates over copies of shards, so writers wait only for the copying of a single shard and never
+// for the function.
+func (s *MemoryStorage) Range(fn func(key uint64, metric StampedGaugeMetric) bool) error {
+	type entry struct {
+		key    uint64
+		metric StampedGaugeMetric
+	}
+
+	var entries []entry
+	for i := range s.shards {
+		shard := &s.shards[i]
+
+		shard.mu.RLock()
+		entries = entries[:0]
+		for key, metric := range shard.metrics {
+			entries = append(entries, entry{key: key, metric: metric})
+		}
+		shard.mu.RUnlock()
+
+		for _, e := range entries {
+			if !fn(e.key, e.metric) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// ExpireBefore pops due entries from expiry heaps of shards. Shards without due entries are only read locked.
+func (s *MemoryStorage) ExpireBefore(t time.Time) (int, error) {
+	expired := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+
+		shard.mu.RLock()
+		due := len(shard.expiry) > 0 && shard.expiry[0].expiresAt.Before(t)
+		shard.mu.RUnlock()
+		if !due {
+			continue
+		}
+
+		shard.mu.Lock()
+		for len(shard.expiry) > 0 && shard.expiry[0].expiresAt.Before(t) {
+			entry := heap.Pop(&shard.expiry).(expiryEntry)
+
+			metric, ok := shard.metrics[entry.key]
+			if !ok || !metric.ExpiresAt().Equal(entry.expiresAt) {
+				// The metric was deleted or updated since the entry was indexed.
+				continue
+			}
+			delete(shard.metrics, entry.key)
+			expired++
+		}
+		shard.mu.Unlock()
+	}
+	return expired, nil
+}
+
+// Drop removes all metrics, memory is released once the storage is not referenced anymore.
+func (s *MemoryStorage) Drop() error {
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		shard.metrics = make(map[uint64]StampedGaugeMetric)
+		shard.expiry = nil
+		shard.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Len() int {
+	total := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		total += len(shard.metrics)
+		shard.mu.RUnlock()
+	}
+	return total
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStorageExpiryHeapIsBounded(t *testing.T) {
+	s := NewMemoryStorage()
+	start := time.Unix(1600000000, 0)
+
+	// The same metric is updated many times, every update adds an entry to the expiry heap.
+	for i := 0; i < 10000; i++ {
+		require.NoError(t, s.Put(1, StampedGaugeMetric{LastUpdate: start.Add(time.Duration(i) * time.Second)}))
+	}
+
+	shard := s.shard(1)
+	require.LessOrEqual(t, len(shard.expiry), 2+expiryHeapSlack)
+
+	// Outdated entries do not expire the updated metric.
+	expired, err := s.ExpireBefore(start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 0, expired)
+	require.Equal(t, 1, s.Len())
+}
+
+func TestRemoveStaleMetrics(t *testing.T) {
+	curTime := time.Unix(1600000000, 0)
+
+	v := NewVault()
+	v.now = func() time.Time { return curTime }
+	require.NoError(t, v.RegisterMappings([]Mapping{{Name: "test_cleanup_metric", LabelNames: []string{"name"}, TTL: time.Hour}}))
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, v.Store("test_cleanup_metric", Sample{
+			Labels:    prometheus.Labels{"name": fmt.Sprint(i)},
+			Value:     1,
+			Timestamp: curTime.Add(-time.Duration(i) * 10 * time.Minute),
+		}))
+	}
+
+	before := testutil.ToFloat64(expiredSeries.WithLabelValues("test_cleanup_metric"))
+	v.RemoveStaleMetrics()
+
+	// Samples updated 70, 80 and 90 minutes ago are expired.
+	require.Equal(t, 7, v.MappingsStatus()[0].Series)
+	require.Equal(t, 3.0, testutil.ToFloat64(expiredSeries.WithLabelValues("test_cleanup_metric"))-before)
+}
+
+// BenchmarkRemoveStaleMetrics measures a cleanup tick when nothing is due, which is the most frequent case.
+func BenchmarkRemoveStaleMetrics(b *testing.B) {
+	for _, series := range []int{10_000, 100_000} {
+		b.Run(fmt.Sprintf("series=%d", series), func(b *testing.B) {
+			v := NewVault()
+			require.NoError(b, v.RegisterMappings([]Mapping{{Name: "test_metric", LabelNames: []string{"name"}, TTL: time.Hour}}))
+			for i := 0; i < series; i++ {
+				require.NoError(b, v.Store("test_metric", Sample{Labels: prometheus.Labels{"name": fmt.Sprint(i)}, Value: 1}))
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				v.RemoveStaleMetrics()
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nabokihms/events_exporter/pkg/vault"
+	"github.com/nabokihms/events_exporter/pkg/vault/storagetest"
+)
+
+func openBoltDB(t *testing.T, path string) *vault.BoltDB {
+	db, err := vault.OpenBoltDB(path)
+	require.NoError(t, err)
+	return db
+}
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) vault.Storage {
+		return vault.NewMemoryStorage()
+	})
+}
+
+func TestBoltStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) vault.Storage {
+		db := openBoltDB(t, filepath.Join(t.TempDir(), "vault.db"))
+		t.Cleanup(func() { require.NoError(t, db.Close()) })
+
+		storage, err := db.StorageFactory(vault.Mapping{Name: "test_metric"})
+		require.NoError(t, err)
+		return storage
+	})
+}
+
+func TestBoltVaultSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.db")
+	mappings := []vault.Mapping{{Name: "test_metric", LabelNames: []string{"name"}, TTL: time.Hour}}
+
+	db := openBoltDB(t, path)
+	v := vault.NewVaultWithStorage(db.StorageFactory)
+	require.NoError(t, v.RegisterMappings(mappings))
+	require.NoError(t, v.Store("test_metric", vault.Sample{ID: "uid-1", Labels: prometheus.Labels{"name": "pod"}, Value: 1}))
+	require.NoError(t, db.Close())
+
+	db = openBoltDB(t, path)
+	defer db.Close()
+	v = vault.NewVaultWithStorage(db.StorageFactory)
+	require.NoError(t, v.RegisterMappings(mappings))
+
+	samples := v.Samples()
+	require.Len(t, samples, 1)
+	require.Equal(t, "uid-1", samples[0].ID)
+	require.Equal(t, 1, v.MappingsStatus()[0].Series)
+}
+
+func TestBoltVaultDropsUnregisteredMappings(t *testing.T) {
+	db := openBoltDB(t, filepath.Join(t.TempDir(), "vault.db"))
+	defer db.Close()
+
+	v := vault.NewVaultWithStorage(db.StorageFactory)
+	mappings := []vault.Mapping{{Name: "test_metric", LabelNames: []string{"name"}, TTL: time.Hour}}
+	require.NoError(t, v.RegisterMappings(mappings))
+	require.NoError(t, v.Store("test_metric", vault.Sample{ID: "uid-1", Labels: prometheus.Labels{"name": "pod"}, Value: 1}))
+
+	unregistered, err := v.UnregisterMapping("test_metric")
+	require.NoError(t, err)
+	require.True(t, unregistered)
+
+	// Series stored with old labels are not restored.
+	mappings[0].LabelNames = []string{"reason", "name"}
+	require.NoError(t, v.RegisterMappings(mappings))
+	require.Empty(t, v.Samples())
+	require.Equal(t, 0, v.MappingsStatus()[0].Series)
+}
+
+func TestBoltStorageAfterDrop(t *testing.T) {
+	db := openBoltDB(t, filepath.Join(t.TempDir(), "vault.db"))
+	defer db.Close()
+
+	storage, err := db.StorageFactory(vault.Mapping{Name: "test_metric"})
+	require.NoError(t, err)
+	require.NoError(t, storage.Drop())
+
+	// Collectors still referenced by a scrape get errors instead of panics.
+	_, _, err = storage.Get(1)
+	require.Error(t, err)
+	require.Error(t, storage.Put(1, vault.StampedGaugeMetric{}))
+	require.Error(t, storage.Delete(1))
+	require.Error(t, storage.Range(func(uint64, vault.StampedGaugeMetric) bool { return true }))
+	_, err = storage.ExpireBefore(time.Now())
+	require.Error(t, err)
+}
+
+func TestBoltVaultUnregisterWhileCollecting(t *testing.T) {
+	db := openBoltDB(t, filepath.Join(t.TempDir(), "vault.db"))
+	defer db.Close()
+
+	v := vault.NewVaultWithStorage(db.StorageFactory)
+	mappings := []vault.Mapping{{Name: "test_metric", LabelNames: []string{"name"}, TTL: time.Hour}}
+	require.NoError(t, v.RegisterMappings(mappings))
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_ = v.Store("test_metric", vault.Sample{ID: "uid-1", Labels: prometheus.Labels{"name": "pod"}, Value: 1})
+			_, _ = v.Gather()
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		_, err := v.UnregisterMapping("test_metric")
+		require.NoError(t, err)
+		require.NoError(t, v.RegisterMappings(mappings))
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storagetest is the conformance test suite for vault storage implementations.
+package storagetest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nabokihms/events_exporter/pkg/vault"
+)
+
+var baseTime = time.Unix(1600000000, 0)
+
+func metric(name string, lastUpdate time.Duration) vault.StampedGaugeMetric {
+	return vault.StampedGaugeMetric{
+		Value:       1,
+		LabelValues: []string{name, ""},
+		FirstSeen:   baseTime,
+		LastUpdate:  baseTime.Add(lastUpdate),
+		Exemplar:    map[string]string{"event_uid": "uid-" + name},
+		ID:          "uid-" + name,
+	}
+}
+
+func requireMetric(t *testing.T, expected, actual vault.StampedGaugeMetric) {
+	t.Helper()
+	require.Equal(t, expected.Value, actual.Value)
+	require.Equal(t, expected.LabelValues, actual.LabelValues)
+	require.True(t, expected.FirstSeen.Equal(actual.FirstSeen), "first seen %v != %v", expected.FirstSeen, actual.FirstSeen)
+	require.True(t, expected.LastUpdate.Equal(actual.LastUpdate), "last update %v != %v", expected.LastUpdate, actual.LastUpdate)
+	require.Equal(t, expected.TTL, actual.TTL)
+	require.Equal(t, expected.Exemplar, actual.Exemplar)
+	require.Equal(t, expected.ID, actual.ID)
+}
+
+func keys(t *testing.T, s vault.Storage) []uint64 {
+	t.Helper()
+	var result []uint64
+	require.NoError(t, s.Range(func(key uint64, _ vault.StampedGaugeMetric) bool {
+		result = append(result, key)
+		return true
+	}))
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// Run checks that the storage created by the factory behaves as the vault expects. Every subtest gets a new storage.
+func Run(t *testing.T, newStorage func(t *testing.T) vault.Storage) {
+	t.Run("get missing", func(t *testing.T) {
+		s := newStorage(t)
+		_, ok, err := s.Get(1)
+		require.NoError(t, err)
+		require.False(t, ok)
+		require.Equal(t, 0, s.Len())
+	})
+
+	t.Run("put and get", func(t *testing.T) {
+		s := newStorage(t)
+		expected := metric("a", 0)
+		require.NoError(t, s.Put(1, expected))
+
+		actual, ok, err := s.Get(1)
+		require.NoError(t, err)
+		require.True(t, ok)
+		requireMetric(t, expected, actual)
+		require.Equal(t, 1, s.Len())
+	})
+
+	t.Run("put replaces", func(t *testing.T) {
+		s := newStorage(t)
+		require.NoError(t, s.Put(1, metric("a", 0)))
+
+		expected := metric("b", time.Minute)
+		expected.Value = 5
+		require.NoError(t, s.Put(1, expected))
+
+		actual, ok, err := s.Get(1)
+		require.NoError(t, err)
+		require.True(t, ok)
+		requireMetric(t, expected, actual)
+		require.Equal(t, 1, s.Len())
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s := newStorage(t)
+		require.NoError(t, s.Put(1, metric("a", 0)))
+		require.NoError(t, s.Put(2, metric("b", 0)))
+
+		require.NoError(t, s.Delete(1))
+		// Deleting missing keys is not an error.
+		require.NoError(t, s.Delete(1))
+		require.NoError(t, s.Delete(3))
+
+		_, ok, err := s.Get(1)
+		require.NoError(t, err)
+		require.False(t, ok)
+		require.Equal(t, []uint64{2}, keys(t, s))
+		require.Equal(t, 1, s.Len())
+	})
+
+	t.Run("range", func(t *testing.T) {
+		s := newStorage(t)
+		for i := uint64(1); i <= 100; i++ {
+			require.NoError(t, s.Put(i*7919, metric(fmt.Sprint(i), 0)))
+		}
+
+		visited := keys(t, s)
+		require.Len(t, visited, 100)
+		for i, key := range visited {
+			require.Equal(t, uint64(i+1)*7919, key)
+		}
+
+		// Range stops once the function returns false.
+		calls := 0
+		require.NoError(t, s.Range(func(uint64, vault.StampedGaugeMetric) bool {
+			calls++
+			return calls < 10
+		}))
+		require.Equal(t, 10, calls)
+	})
+
+	t.Run("expire before", func(t *testing.T) {
+		s := newStorage(t)
+		require.NoError(t, s.Put(1, metric("old", -2*time.Hour)))
+		require.NoError(t, s.Put(2, metric("stale", -time.Hour)))
+		require.NoError(t, s.Put(3, metric("fresh", 0)))
+		// The metric was stale, but it was updated since.
+		require.NoError(t, s.Put(4, metric("updated", -3*time.Hour)))
+		require.NoError(t, s.Put(4, metric("updated", time.Minute)))
+
+		expired, err := s.ExpireBefore(baseTime.Add(-time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, 1, expired)
+		require.Equal(t, []uint64{2, 3, 4}, keys(t, s))
+
+		// Metrics updated exactly at the time are kept.
+		expired, err = s.ExpireBefore(baseTime)
+		require.NoError(t, err)
+		require.Equal(t, 1, expired)
+		require.Equal(t, []uint64{3, 4}, keys(t, s))
+		require.Equal(t, 2, s.Len())
+
+		// Deleted metrics do not expire later.
+		require.NoError(t, s.Delete(3))
+		expired, err = s.ExpireBefore(baseTime.Add(time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, 1, expired)
+		require.Equal(t, 0, s.Len())
+	})
+
+	t.Run("expire by ttl", func(t *testing.T) {
+		s := newStorage(t)
+
+		// The older metric lives longer, metrics expire by the last update plus their own TTL.
+		longLived := metric("long-lived", -2*time.Hour)
+		longLived.TTL = 24 * time.Hour
+		require.NoError(t, s.Put(1, longLived))
+		shortLived := metric("short-lived", -time.Hour)
+		shortLived.TTL = 10 * time.Minute
+		require.NoError(t, s.Put(2, shortLived))
+
+		stored, ok, err := s.Get(1)
+		require.NoError(t, err)
+		require.True(t, ok)
+		requireMetric(t, longLived, stored)
+
+		expired, err := s.ExpireBefore(baseTime)
+		require.NoError(t, err)
+		require.Equal(t, 1, expired)
+		require.Equal(t, []uint64{1}, keys(t, s))
+
+		// Changing the TTL without an update moves the expiration.
+		longLived.TTL = time.Hour
+		require.NoError(t, s.Put(1, longLived))
+		expired, err = s.ExpireBefore(baseTime)
+		require.NoError(t, err)
+		require.Equal(t, 1, expired)
+		require.Equal(t, 0, s.Len())
+	})
+
+	t.Run("concurrent writes", func(t *testing.T) {
+		s := newStorage(t)
+
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					key := uint64(w*1000 + i)
+					require.NoError(t, s.Put(key, metric(fmt.Sprint(key), 0)))
+					_, _, err := s.Get(key)
+					require.NoError(t, err)
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		require.Equal(t, 400, s.Len())
+		require.Len(t, keys(t, s), 400)
+	})
+	t.Run("drop", func(t *testing.T) {
+		s := newStorage(t)
+		require.NoError(t, s.Put(1, metric("a", 0)))
+		require.NoError(t, s.Put(2, metric("b", 0)))
+
+		require.NoError(t, s.Drop())
+		require.Equal(t, 0, s.Len())
+	})
+}
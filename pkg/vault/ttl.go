@@ -0,0 +1,88 @@
+// Copyright 2021 The Events Exporter authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// TTLRule overrides the mapping TTL for samples with matching labels.
+type TTLRule struct {
+	// Match maps label names to anchored regular expressions, a sample matches if all of them match.
+	Match map[string]string `yaml:"match"`
+	TTL   time.Duration     `yaml:"ttl"`
+}
+
+type ttlRule struct {
+	matchers []labelMatcher
+	ttl      time.Duration
+}
+
+type labelMatcher struct {
+	index int
+	re    *regexp.Regexp
+}
+
+func compileTTLRules(mapping Mapping) ([]ttlRule, error) {
+	labelIndexes := make(map[string]int, len(mapping.LabelNames))
+	for i, name := range mapping.LabelNames {
+		labelIndexes[name] = i
+	}
+
+	rules := make([]ttlRule, 0, len(mapping.TTLRules))
+	for i, r := range mapping.TTLRules {
+		if r.TTL <= 0 {
+			return nil, fmt.Errorf("ttl rule #%d of %q: ttl must be positive", i, mapping.Name)
+		}
+		if len(r.Match) == 0 {
+			return nil, fmt.Errorf("ttl rule #%d of %q: match at least one label or change the mapping ttl", i, mapping.Name)
+		}
+
+		rule := ttlRule{ttl: r.TTL}
+		for name, pattern := range r.Match {
+			index, ok := labelIndexes[name]
+			if !ok {
+				return nil, fmt.Errorf("ttl rule #%d of %q: unknown label %q", i, mapping.Name, name)
+			}
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("ttl rule #%d of %q: %w", i, mapping.Name, err)
+			}
+			rule.matchers = append(rule.matchers, labelMatcher{index: index, re: re})
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// effectiveTTL returns the TTL of the first matching rule or the mapping TTL.
+func effectiveTTL(rules []ttlRule, fallback time.Duration, labelValues []string) time.Duration {
+	for _, rule := range rules {
+		if rule.match(labelValues) {
+			return rule.ttl
+		}
+	}
+	return fallback
+}
+
+func (r ttlRule) match(labelValues []string) bool {
+	for _, m := range r.matchers {
+		if m.index >= len(labelValues) || !m.re.MatchString(labelValues[m.index]) {
+			return false
+		}
+	}
+	return true
+}
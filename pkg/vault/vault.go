@@ -15,63 +15,348 @@ package vault
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
 )
 
+var (
+	cleanupDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "events_exporter_vault_cleanup_duration_seconds",
+		Help:    "Time spent removing stale metrics",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+	expiredSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_exporter_vault_expired_series_total",
+		Help: "Series removed from the vault after their TTL",
+	}, []string{"metric"})
+)
+
+// MustRegisterMetrics registers metrics of the vault itself in the registry.
+func MustRegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(cleanupDuration, expiredSeries)
+}
+
 type MetricsVault struct {
 	now func() time.Time
 
-	metrics map[string]ConstMetricCollector
+	registry   *prometheus.Registry
+	newStorage StorageFactory
+
+	// mu guards mappings registered at runtime, collectors are safe for concurrent use themselves.
+	mu       sync.RWMutex
+	metrics  map[string]ConstMetricCollector
+	mappings map[string]Mapping
+
+	listeners []StoreListener
 }
 
+// StoreListener receives stored samples. OnStore is called synchronously after every stored sample, so it must
+// not block.
+type StoreListener interface {
+	// Listening reports whether the listener needs samples now, the vault does not build samples otherwise.
+	Listening() bool
+	OnStore(sample StoredSample)
+}
+
+// StoreListenerFunc adapts the function to the StoreListener always listening.
+type StoreListenerFunc func(sample StoredSample)
+
+func (f StoreListenerFunc) Listening() bool { return true }
+
+func (f StoreListenerFunc) OnStore(sample StoredSample) { f(sample) }
+
+const (
+	GaugeType   = "gauge"
+	CounterType = "counter"
+)
+
 type Mapping struct {
 	Name string `yaml:"name"`
 	Help string `yaml:"help,omitempty"`
+	// Type is a prometheus metric type, either gauge (default) or counter.
+	// Only counters expose exemplars.
+	Type string `yaml:"type,omitempty"`
 
 	LabelNames []string      `yaml:"labels,omitempty"`
 	TTL        time.Duration `yaml:"ttl,omitempty"`
+	// TTLRules override the TTL for samples with matching label values. The first matching rule wins,
+	// and TTL is the fallback.
+	TTLRules []TTLRule `yaml:"ttl_rules,omitempty"`
+	// SeriesLimit is the max number of series of the mapping, new series over the limit are rejected.
+	// Zero means no limit.
+	SeriesLimit int `yaml:"series_limit,omitempty"`
+
+	// FirstTimestampName and LastTimestampName are names of companion gauges exposing when each sample was first
+	// seen and last updated in unix seconds. Companion gauges are not exposed if names are empty.
+	FirstTimestampName string `yaml:"first_timestamp_name,omitempty"`
+	LastTimestampName  string `yaml:"last_timestamp_name,omitempty"`
+	// ExplicitTimestamps exposes samples with the timestamp of their last update instead of the scrape time.
+	ExplicitTimestamps bool `yaml:"explicit_timestamps,omitempty"`
+}
+
+// Validate checks that the mapping can be registered, so samples do not fail later on collection.
+func (m Mapping) Validate() error {
+	if !model.IsValidMetricName(model.LabelValue(m.Name)) {
+		return fmt.Errorf("invalid metric name %q", m.Name)
+	}
+	for _, name := range []string{m.FirstTimestampName, m.LastTimestampName} {
+		if name != "" && !model.IsValidMetricName(model.LabelValue(name)) {
+			return fmt.Errorf("invalid timestamp metric name %q for %q", name, m.Name)
+		}
+	}
+
+	seen := make(map[string]bool, len(m.LabelNames))
+	for _, name := range m.LabelNames {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid label name %q for %q", name, m.Name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate label name %q for %q", name, m.Name)
+		}
+		seen[name] = true
+	}
+
+	if m.SeriesLimit < 0 {
+		return fmt.Errorf("negative series limit for %q", m.Name)
+	}
+	if _, err := m.valueType(); err != nil {
+		return err
+	}
+	_, err := compileTTLRules(m)
+	return err
+}
+
+func (m Mapping) valueType() (prometheus.ValueType, error) {
+	switch m.Type {
+	case "", GaugeType:
+		return prometheus.GaugeValue, nil
+	case CounterType:
+		return prometheus.CounterValue, nil
+	default:
+		return 0, fmt.Errorf("unknown metric type %q for %q", m.Type, m.Name)
+	}
 }
 
 type Sample struct {
 	// ID is a sample unique id e.g., labels hash, uuid.
 	ID string
-	// Labels are label values in determined order.
-	Labels []string
+	// Labels are label values by label names of the mapping. Labels missing in the sample are stored empty,
+	// and labels unknown to the mapping are rejected by Store.
+	Labels prometheus.Labels
 	// Value is a sample latest sample value.
 	Value float64
 	// Timestamp is the time sample was collected.
 	// Events exporter will collect the expired sample basing on this field.
 	Timestamp time.Time
+	// FirstTimestamp is the time sample was first collected at the source.
+	FirstTimestamp time.Time
+	// Exemplar are labels linking the sample to its source, e.g., the event uid.
+	// They are exposed only for counters in the OpenMetrics format.
+	Exemplar prometheus.Labels
 }
 
+// NewVault returns a vault with its own registry, so vaults do not share metrics with each other.
+// Metrics are kept in memory.
 func NewVault() *MetricsVault {
-	return &MetricsVault{now: time.Now, metrics: make(map[string]ConstMetricCollector)}
+	return NewVaultWithStorage(MemoryStorageFactory)
+}
+
+// NewVaultWithStorage returns a vault keeping metrics of every mapping in the storage created by the factory.
+func NewVaultWithStorage(newStorage StorageFactory) *MetricsVault {
+	return &MetricsVault{
+		now:        time.Now,
+		registry:   prometheus.NewRegistry(),
+		newStorage: newStorage,
+		metrics:    make(map[string]ConstMetricCollector),
+		mappings:   make(map[string]Mapping),
+	}
 }
 
 func (v *MetricsVault) RegisterMappings(mappings []Mapping) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
 	for _, mapping := range mappings {
-		collector := NewConstGaugeCollector(mapping)
-		v.metrics[mapping.Name] = collector
+		if err := mapping.Validate(); err != nil {
+			return fmt.Errorf("mapping registration: %v", err)
+		}
+
+		storage, err := v.newStorage(mapping)
+		if err != nil {
+			return fmt.Errorf("mapping registration: %v", err)
+		}
 
-		if err := prometheus.Register(collector); err != nil {
+		collector := NewGaugeCollectorWithStorage(mapping, storage)
+		if err := v.registry.Register(collector); err != nil {
 			return fmt.Errorf("mapping registration: %v", err)
 		}
+
+		v.metrics[mapping.Name] = collector
+		v.mappings[mapping.Name] = mapping
 	}
 	return nil
 }
 
+// UnregisterMapping removes the mapping from the vault and drops its storage, so the mapping registered again,
+// maybe with other labels, starts from scratch. It returns false if the mapping is not registered. The mapping is
+// removed even if dropping the storage fails.
+func (v *MetricsVault) UnregisterMapping(name string) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	collector, ok := v.metrics[name]
+	if !ok {
+		return false, nil
+	}
+	delete(v.metrics, name)
+	delete(v.mappings, name)
+
+	// Registries keep label names of unregistered metrics forever, so the registry is rebuilt to let
+	// the metric be registered again with other labels.
+	registry := prometheus.NewRegistry()
+	for _, collector := range v.metrics {
+		registry.MustRegister(collector)
+	}
+	v.registry = registry
+
+	if err := collector.Drop(); err != nil {
+		return true, fmt.Errorf("drop %s storage: %w", name, err)
+	}
+	return true, nil
+}
+
+// Store stores the sample holding the read lock, so the mapping cannot be unregistered and its storage dropped
+// in the middle.
 func (v *MetricsVault) Store(index string, sample Sample) error {
-	binding := v.metrics[index]
-	binding.Store(v.now(), sample)
+	v.mu.RLock()
+	binding, ok := v.metrics[index]
+	if !ok {
+		v.mu.RUnlock()
+		return fmt.Errorf("store %s: %w", index, ErrUnknownMapping)
+	}
+	stored, err := binding.Store(v.now(), sample)
+	if err != nil {
+		v.mu.RUnlock()
+		return fmt.Errorf("store %s: %w", index, err)
+	}
+
+	var listeners []StoreListener
+	for _, listener := range v.listeners {
+		if listener.Listening() {
+			listeners = append(listeners, listener)
+		}
+	}
+
+	var storedSample StoredSample
+	if len(listeners) > 0 {
+		storedSample = v.storedSample(index, stored)
+	}
+	v.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener.OnStore(storedSample)
+	}
 	return nil
 }
 
+// AddStoreListener subscribes the listener to stored samples. Listeners must be added before samples are stored.
+func (v *MetricsVault) AddStoreListener(listener StoreListener) {
+	v.listeners = append(v.listeners, listener)
+}
+
+// Gather implements prometheus.Gatherer to take a snapshot of stored metrics. Collectors are not unregistered
+// while they are collected.
+func (v *MetricsVault) Gather() ([]*dto.MetricFamily, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.registry.Gather()
+}
+
+// StoredSample is a sample held by the vault with its labels named after the mapping.
+type StoredSample struct {
+	Metric     string
+	Labels     map[string]string
+	Value      float64
+	FirstSeen  time.Time
+	LastUpdate time.Time
+	// TTL is the effective TTL of the sample, either from the first matching TTL rule or from the mapping.
+	TTL time.Duration
+	// ExpiresAt is the time the sample will be removed at unless it is updated.
+	ExpiresAt time.Time
+	// ID is the id of the last stored sample, e.g., the source event uid.
+	ID string
+}
+
+// Samples returns all samples currently held by the vault.
+func (v *MetricsVault) Samples() []StoredSample {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	var samples []StoredSample
+	for name, m := range v.metrics {
+		for _, metric := range m.Snapshot() {
+			samples = append(samples, v.storedSample(name, metric))
+		}
+	}
+	return samples
+}
+
+func (v *MetricsVault) storedSample(name string, metric StampedGaugeMetric) StoredSample {
+	mapping := v.mappings[name]
+	labels := make(map[string]string, len(mapping.LabelNames))
+	for i, labelName := range mapping.LabelNames {
+		if i < len(metric.LabelValues) {
+			labels[labelName] = metric.LabelValues[i]
+		}
+	}
+
+	return StoredSample{
+		Metric:     name,
+		Labels:     labels,
+		Value:      metric.Value,
+		FirstSeen:  metric.FirstSeen,
+		LastUpdate: metric.LastUpdate,
+		TTL:        metric.TTL,
+		ExpiresAt:  metric.ExpiresAt(),
+		ID:         metric.ID,
+	}
+}
+
+// MappingStatus is a registered mapping with the number of series it currently holds.
+type MappingStatus struct {
+	Mapping
+	Series int
+}
+
+// MappingsStatus returns registered mappings sorted by name.
+func (v *MetricsVault) MappingsStatus() []MappingStatus {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	status := make([]MappingStatus, 0, len(v.mappings))
+	for name, mapping := range v.mappings {
+		status = append(status, MappingStatus{Mapping: mapping, Series: v.metrics[name].Len()})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+	return status
+}
+
+// RemoveStaleMetrics removes metrics not updated for longer than their effective TTLs.
 func (v *MetricsVault) RemoveStaleMetrics() {
+	start := time.Now()
 	currentTime := v.now()
 
-	for _, m := range v.metrics {
-		m.Clear(currentTime)
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for name, m := range v.metrics {
+		if expired := m.Clear(currentTime); expired > 0 {
+			expiredSeries.WithLabelValues(name).Add(float64(expired))
+		}
 	}
+	cleanupDuration.Observe(time.Since(start).Seconds())
 }